@@ -0,0 +1,291 @@
+package entre
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// APIKey describes an API key as held in a key store.
+// Only the SHA-256 hash of the key itself is kept.
+type APIKey struct {
+	// ID identifies the key and becomes the ID of the authenticated principal.
+	ID string `json:"id"`
+	// Name is a human readable name for the key's owner.
+	Name string `json:"name,omitempty"`
+	// Hash is the hex encoded SHA-256 hash of the key, see HashAPIKey.
+	Hash string `json:"hash"`
+	// Scopes are the scopes granted to the key, which become the principal's roles.
+	Scopes []string `json:"scopes,omitempty"`
+	// Expires is when the key stops being accepted, it never expires when zero.
+	Expires time.Time `json:"expires,omitempty"`
+}
+
+// Expired reports whether or not the key had expired at the provided time.
+func (k *APIKey) Expired(now time.Time) bool {
+	return !k.Expires.IsZero() && !now.Before(k.Expires)
+}
+
+// HashAPIKey provides the hash API keys are stored under.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// GenerateAPIKey generates a new random API key.
+func GenerateAPIKey() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// APIKeyStore looks up API keys for the API key middleware.
+type APIKeyStore interface {
+	// Lookup provides the key matching the provided key as sent by the client.
+	Lookup(key string) (*APIKey, bool)
+}
+
+// MemoryKeyStore is an in-memory APIKeyStore.
+type MemoryKeyStore struct {
+	mu   sync.RWMutex
+	keys map[string]*APIKey
+}
+
+// NewMemoryKeyStore creates a new empty in-memory key store.
+func NewMemoryKeyStore() *MemoryKeyStore {
+	return &MemoryKeyStore{keys: map[string]*APIKey{}}
+}
+
+// Add adds the provided key, its Hash is set from the raw key.
+func (s *MemoryKeyStore) Add(key string, k *APIKey) {
+	k.Hash = HashAPIKey(key)
+	s.AddHashed(k)
+}
+
+// AddHashed adds the provided key which already has its Hash set.
+func (s *MemoryKeyStore) AddHashed(k *APIKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.keys == nil {
+		s.keys = map[string]*APIKey{}
+	}
+	s.keys[strings.ToLower(k.Hash)] = k
+}
+
+// Remove removes the key with the provided ID.
+func (s *MemoryKeyStore) Remove(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for hash, k := range s.keys {
+		if k.ID == id {
+			delete(s.keys, hash)
+		}
+	}
+}
+
+// Lookup provides the key matching the provided key.
+func (s *MemoryKeyStore) Lookup(key string) (*APIKey, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	// Keys are looked up by their hash so the lookup doesn't leak anything about the key.
+	k, ok := s.keys[HashAPIKey(key)]
+	return k, ok
+}
+
+// FileKeyStore is an APIKeyStore backed by a JSON file holding an array of APIKey objects:
+//
+//	[{"id": "billing", "hash": "<hex SHA-256 of the key>", "scopes": ["invoices:read"], "expires": "2030-01-01T00:00:00Z"}]
+//
+// The file is reloaded when it changes.
+type FileKeyStore struct {
+	// Filename is the path of the JSON file.
+	Filename string
+	// CheckInterval is how often the file is checked for changes.
+	CheckInterval time.Duration
+
+	mu    sync.RWMutex
+	store *MemoryKeyStore
+	watch fileWatch
+}
+
+// NewFileKeyStore loads the provided JSON key file, which is checked
+// for changes at most every 5 seconds.
+func NewFileKeyStore(filename string) (*FileKeyStore, error) {
+	s := &FileKeyStore{
+		Filename:      filename,
+		CheckInterval: 5 * time.Second,
+	}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload re-reads the key file.
+func (s *FileKeyStore) Reload() error {
+	info, err := os.Stat(s.Filename)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(s.Filename)
+	if err != nil {
+		return err
+	}
+	var keys []*APIKey
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return err
+	}
+	store := NewMemoryKeyStore()
+	for _, k := range keys {
+		store.AddHashed(k)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.store = store
+	s.watch.loaded(info)
+	return nil
+}
+
+// Lookup provides the key matching the provided key.
+func (s *FileKeyStore) Lookup(key string) (*APIKey, bool) {
+	if s.watch.changed(s.Filename, s.CheckInterval) {
+		s.Reload()
+	}
+	s.mu.RLock()
+	store := s.store
+	s.mu.RUnlock()
+	return store.Lookup(key)
+}
+
+// APIKeyAuth provides the API key and bearer token authentication middleware.
+// The key is taken from the Header, the Query parameter or the Cookie, whichever
+// are set, in that order.
+type APIKeyAuth struct {
+	// Store looks up the keys provided by clients.
+	Store APIKeyStore
+	// Header is the header the key is sent in, such as Authorization or X-API-Key.
+	Header string
+	// Scheme is the auth scheme the header value must start with, such as Bearer.
+	// The whole header value is the key when empty.
+	Scheme string
+	// Query is the query parameter the key can be sent in.
+	Query string
+	// Cookie is the cookie the key can be sent in.
+	Cookie string
+	// Realm is the protection space sent to clients in the WWW-Authenticate header.
+	Realm string
+	// Lockout throttles repeated failures per client IP, when set.
+	// Locked out clients are responded to with a 429 Too Many Requests.
+	Lockout *Lockout
+	// OnFailure is called for each failed attempt, when set.
+	OnFailure func(r *http.Request, failure *AuthFailure)
+}
+
+// NewAPIKeyAuth creates a new API key auth instance which expects
+// bearer tokens in the Authorization header.
+func NewAPIKeyAuth(store APIKeyStore) *APIKeyAuth {
+	return &APIKeyAuth{
+		Store:  store,
+		Header: "Authorization",
+		Scheme: "Bearer",
+		Realm:  "Restricted",
+	}
+}
+
+func (a *APIKeyAuth) ServeHTTP(w http.ResponseWriter, r *http.Request, ps httprouter.Params, next http.HandlerFunc) {
+	key := credentialFrom(r, a.Header, a.Scheme, a.Query, a.Cookie)
+	if key == "" {
+		challengeBearer(w, a.Scheme, a.Realm, "")
+		return
+	}
+	ipKey := "ip:" + ClientIPFrom(r)
+	if a.Lockout != nil {
+		if remaining := a.Lockout.Locked(ipKey); remaining > 0 {
+			a.fail(r, "", "locked out", remaining)
+			tooManyRequests(w, remaining)
+			return
+		}
+	}
+	var k *APIKey
+	ok := false
+	if a.Store != nil {
+		k, ok = a.Store.Lookup(key)
+	}
+	if !ok || k.Expired(time.Now()) {
+		var remaining time.Duration
+		if a.Lockout != nil {
+			remaining = a.Lockout.Fail(ipKey)
+		}
+		reason := "invalid credentials"
+		user := ""
+		if ok {
+			reason, user = "expired credentials", k.ID
+		}
+		a.fail(r, user, reason, remaining)
+		challengeBearer(w, a.Scheme, a.Realm, "invalid_token")
+		return
+	}
+	name := k.Name
+	if name == "" {
+		name = k.ID
+	}
+	next(w, WithPrincipal(r, &Principal{ID: k.ID, Name: name, Roles: k.Scopes, AuthMethod: "apikey"}))
+}
+
+func (a *APIKeyAuth) fail(r *http.Request, user string, reason string, lockedFor time.Duration) {
+	if a.OnFailure == nil {
+		return
+	}
+	a.OnFailure(r, newAuthFailure(r, user, reason, lockedFor))
+}
+
+// credentialFrom extracts the credential sent by the client from the header, query parameter
+// or cookie, whichever are set, in that order. When a scheme is set the header value
+// must start with it and only what follows is the credential.
+func credentialFrom(r *http.Request, header string, scheme string, query string, cookie string) string {
+	if header != "" {
+		if value := r.Header.Get(header); value != "" {
+			if scheme == "" {
+				return value
+			}
+			if s, token, ok := strings.Cut(value, " "); ok && strings.EqualFold(s, scheme) {
+				return strings.TrimSpace(token)
+			}
+		}
+	}
+	if query != "" {
+		if value := r.URL.Query().Get(query); value != "" {
+			return value
+		}
+	}
+	if cookie != "" {
+		if c, err := r.Cookie(cookie); err == nil {
+			return c.Value
+		}
+	}
+	return ""
+}
+
+// challengeBearer responds with a 401, using the bearer challenge of RFC 6750 for bearer tokens.
+func challengeBearer(w http.ResponseWriter, scheme string, realm string, errorCode string) {
+	if strings.EqualFold(scheme, "Bearer") {
+		challenge := "Bearer realm=" + strconv.Quote(realm)
+		if errorCode != "" {
+			challenge += ", error=" + strconv.Quote(errorCode)
+		}
+		w.Header().Set("WWW-Authenticate", challenge)
+	}
+	http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+}
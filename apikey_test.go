@@ -0,0 +1,109 @@
+package entre
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_APIKeyAuth(t *testing.T) {
+	store := NewMemoryKeyStore()
+	store.Add("valid-key", &APIKey{ID: "billing", Name: "Billing service", Scopes: []string{"invoices:read"}})
+	store.Add("expired-key", &APIKey{ID: "old", Expires: time.Now().Add(-time.Hour)})
+	var principal *Principal
+	var failures []*AuthFailure
+	auth := NewAPIKeyAuth(store)
+	auth.Query = "api_key"
+	auth.Cookie = "api_key"
+	auth.OnFailure = func(r *http.Request, failure *AuthFailure) {
+		failures = append(failures, failure)
+	}
+	e := New(auth)
+	e.PushHandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal = PrincipalFrom(r)
+	})
+	serve := func(url string, setup func(*http.Request)) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			t.Error(err)
+		}
+		setup(req)
+		recorder := httptest.NewRecorder()
+		e.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	missing := serve("http://localhost:8384/test", func(req *http.Request) {})
+	expect(t, missing.Code, http.StatusUnauthorized)
+	expect(t, missing.Header().Get("WWW-Authenticate"), `Bearer realm="Restricted"`)
+	expect(t, len(failures), 0)
+
+	invalid := serve("http://localhost:8384/test", func(req *http.Request) {
+		req.Header.Set("Authorization", "Bearer wrong-key")
+	})
+	expect(t, invalid.Code, http.StatusUnauthorized)
+	expect(t, invalid.Header().Get("WWW-Authenticate"), `Bearer realm="Restricted", error="invalid_token"`)
+
+	expired := serve("http://localhost:8384/test", func(req *http.Request) {
+		req.Header.Set("Authorization", "Bearer expired-key")
+	})
+	expect(t, expired.Code, http.StatusUnauthorized)
+	expect(t, len(failures), 2)
+	expect(t, failures[1].Reason, "expired credentials")
+	expect(t, failures[1].User, "old")
+
+	expect(t, serve("http://localhost:8384/test", func(req *http.Request) {
+		req.Header.Set("Authorization", "bearer valid-key")
+	}).Code, http.StatusOK)
+	expect(t, principal.ID, "billing")
+	expect(t, principal.Name, "Billing service")
+	expect(t, principal.AuthMethod, "apikey")
+	expect(t, principal.HasRole("invoices:read"), true)
+
+	expect(t, serve("http://localhost:8384/test?api_key=valid-key", func(req *http.Request) {}).Code, http.StatusOK)
+	expect(t, serve("http://localhost:8384/test", func(req *http.Request) {
+		req.AddCookie(&http.Cookie{Name: "api_key", Value: "valid-key"})
+	}).Code, http.StatusOK)
+
+	store.Remove("billing")
+	expect(t, serve("http://localhost:8384/test?api_key=valid-key", func(req *http.Request) {}).Code, http.StatusUnauthorized)
+}
+
+func Test_FileKeyStore(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "keys.json")
+	content := `[{"id": "billing", "hash": "` + HashAPIKey("first-key") + `", "scopes": ["invoices:read"]}]`
+	if err := os.WriteFile(name, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewFileKeyStore(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.CheckInterval = 0
+	k, ok := store.Lookup("first-key")
+	expect(t, ok, true)
+	expect(t, k.ID, "billing")
+	expect(t, k.Scopes[0], "invoices:read")
+
+	content = `[{"id": "billing", "hash": "` + HashAPIKey("second-key") + `", "expires": "2000-01-01T00:00:00Z"}]`
+	if err := os.WriteFile(name, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(name, time.Now().Add(time.Minute), time.Now().Add(time.Minute))
+	_, ok = store.Lookup("first-key")
+	expect(t, ok, false)
+	k, ok = store.Lookup("second-key")
+	expect(t, ok, true)
+	expect(t, k.Expired(time.Now()), true)
+}
+
+func Test_GenerateAPIKey(t *testing.T) {
+	first, err := GenerateAPIKey()
+	expect(t, err, nil)
+	second, _ := GenerateAPIKey()
+	refute(t, first, second)
+	expect(t, len(first), 43)
+}
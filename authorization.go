@@ -0,0 +1,152 @@
+package entre
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// Rule grants access to the requests matching its method and path pattern.
+// Path patterns are made up of literal segments, ":name" segments which match any single
+// segment and a final "*" or "*name" segment which matches the rest of the path, like httprouter routes.
+type Rule struct {
+	// Method is the HTTP method the rule applies to, any method when empty or "*".
+	Method string `json:"method,omitempty"`
+	// Path is the path pattern the rule applies to.
+	Path string `json:"path"`
+	// Public allows requests without an authenticated principal.
+	Public bool `json:"public,omitempty"`
+	// Roles are the roles the principal needs at least one of.
+	Roles []string `json:"roles,omitempty"`
+	// Scopes are the scopes the principal needs all of.
+	Scopes []string `json:"scopes,omitempty"`
+}
+
+// matches reports whether or not the rule applies to the provided method and path.
+func (rule *Rule) matches(method string, path string) bool {
+	if rule.Method != "" && rule.Method != "*" && !strings.EqualFold(rule.Method, method) {
+		return false
+	}
+	pattern := strings.Split(strings.Trim(rule.Path, "/"), "/")
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	for i, p := range pattern {
+		if strings.HasPrefix(p, "*") {
+			return true
+		}
+		if i >= len(segments) || (!strings.HasPrefix(p, ":") && p != segments[i]) || (p != "" && segments[i] == "") {
+			return false
+		}
+	}
+	return len(pattern) == len(segments)
+}
+
+// allows reports whether or not the provided principal satisfies the rule.
+func (rule *Rule) allows(p *Principal) bool {
+	if len(rule.Roles) > 0 {
+		allowed := false
+		for _, role := range rule.Roles {
+			allowed = allowed || p.HasRole(role)
+		}
+		if !allowed {
+			return false
+		}
+	}
+	for _, scope := range rule.Scopes {
+		if !p.HasRole(scope) {
+			return false
+		}
+	}
+	return true
+}
+
+// Authorization provides the authorization middleware which checks the principal
+// authenticated by an auth middleware earlier in the chain against a set of rules.
+// The first rule matching the request decides whether it is allowed, requests without
+// a principal are responded to with a 401 Unauthorized and those with a principal
+// lacking the required roles or scopes with a 403 Forbidden.
+type Authorization struct {
+	// Rules are checked in order.
+	Rules []*Rule
+	// DefaultAllow allows authenticated requests which don't match any rule,
+	// otherwise they are forbidden.
+	DefaultAllow bool
+	// OnDenied is called for each denied request, when set, for auditing purposes.
+	OnDenied func(r *http.Request, status int)
+}
+
+// NewAuthorization creates a new authorization instance with the provided rules
+// which forbids requests that don't match any of them.
+func NewAuthorization(rules ...*Rule) *Authorization {
+	return &Authorization{Rules: rules}
+}
+
+// NewAuthorizationFromFile creates a new authorization instance with rules loaded
+// from a JSON file holding an array of rules:
+//
+//	[
+//	  {"method": "GET", "path": "/health", "public": true},
+//	  {"method": "DELETE", "path": "/users/:id", "roles": ["admin"]},
+//	  {"path": "/orders/*", "scopes": ["orders:read"]}
+//	]
+func NewAuthorizationFromFile(filename string) (*Authorization, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var rules []*Rule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, err
+	}
+	return NewAuthorization(rules...), nil
+}
+
+func (a *Authorization) ServeHTTP(w http.ResponseWriter, r *http.Request, ps httprouter.Params, next http.HandlerFunc) {
+	p := PrincipalFrom(r)
+	for _, rule := range a.Rules {
+		if rule.matches(r.Method, r.URL.Path) {
+			if rule.Public {
+				next(w, r)
+				return
+			}
+			a.check(w, r, p, rule.allows(p), next)
+			return
+		}
+	}
+	a.check(w, r, p, a.DefaultAllow, next)
+}
+
+func (a *Authorization) check(w http.ResponseWriter, r *http.Request, p *Principal, allowed bool, next http.HandlerFunc) {
+	status := http.StatusOK
+	switch {
+	case p == nil:
+		status = http.StatusUnauthorized
+	case !allowed:
+		status = http.StatusForbidden
+	}
+	if status == http.StatusOK {
+		next(w, r)
+		return
+	}
+	if a.OnDenied != nil {
+		a.OnDenied(r, status)
+	}
+	http.Error(w, http.StatusText(status), status)
+}
+
+// RequireRoles provides a handler which only lets through requests authenticated as
+// a principal with at least one of the provided roles. This is for route specific stacks
+// used with ForHTTPRouter:
+//
+//	router.DELETE("/users/:id", entre.New(auth, entre.RequireRoles("admin"), entre.UseHTTPRouterHandler(h)).ForHTTPRouter())
+func RequireRoles(roles ...string) Handler {
+	return &Authorization{Rules: []*Rule{{Path: "/*", Roles: roles}}}
+}
+
+// RequireScopes provides a handler which only lets through requests authenticated as
+// a principal with all of the provided scopes, see RequireRoles.
+func RequireScopes(scopes ...string) Handler {
+	return &Authorization{Rules: []*Rule{{Path: "/*", Scopes: scopes}}}
+}
@@ -0,0 +1,111 @@
+package entre
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// withTestPrincipal provides a handler authenticating requests with a principal
+// having the roles listed in the X-Roles header, when present.
+func withTestPrincipal() Handler {
+	return HandlerFunc(func(w http.ResponseWriter, r *http.Request, ps httprouter.Params, next http.HandlerFunc) {
+		if roles, ok := r.Header["X-Roles"]; ok {
+			r = WithPrincipal(r, &Principal{ID: "user", Name: "user", Roles: roles})
+		}
+		next(w, r)
+	})
+}
+
+func serveAuthorization(t *testing.T, e *Entre, method string, path string, roles ...string) int {
+	req, err := http.NewRequest(method, "http://localhost:8384"+path, nil)
+	if err != nil {
+		t.Error(err)
+	}
+	if roles != nil {
+		req.Header["X-Roles"] = roles
+	}
+	recorder := httptest.NewRecorder()
+	e.ServeHTTP(recorder, req)
+	return recorder.Code
+}
+
+func Test_Authorization(t *testing.T) {
+	var denied []int
+	authz := NewAuthorization(
+		&Rule{Method: "GET", Path: "/health", Public: true},
+		&Rule{Method: "DELETE", Path: "/users/:id", Roles: []string{"admin", "owner"}},
+		&Rule{Path: "/users/:id", Roles: []string{"user"}},
+		&Rule{Path: "/orders/*", Scopes: []string{"orders:read", "orders:write"}},
+	)
+	authz.OnDenied = func(r *http.Request, status int) {
+		denied = append(denied, status)
+	}
+	e := New(withTestPrincipal(), authz)
+	e.PushHandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	expect(t, serveAuthorization(t, e, "GET", "/health"), http.StatusOK)
+	expect(t, serveAuthorization(t, e, "GET", "/users/1"), http.StatusUnauthorized)
+	expect(t, serveAuthorization(t, e, "GET", "/users/1", "user"), http.StatusOK)
+	expect(t, serveAuthorization(t, e, "GET", "/users/1", "guest"), http.StatusForbidden)
+	expect(t, serveAuthorization(t, e, "DELETE", "/users/1", "user"), http.StatusForbidden)
+	expect(t, serveAuthorization(t, e, "DELETE", "/users/1", "owner"), http.StatusOK)
+	expect(t, serveAuthorization(t, e, "GET", "/users/1/posts", "user"), http.StatusForbidden)
+	expect(t, serveAuthorization(t, e, "POST", "/orders/1/items", "orders:read"), http.StatusForbidden)
+	expect(t, serveAuthorization(t, e, "POST", "/orders/1/items", "orders:read", "orders:write"), http.StatusOK)
+	expect(t, len(denied), 5)
+	expect(t, denied[0], http.StatusUnauthorized)
+	expect(t, denied[1], http.StatusForbidden)
+
+	authz.DefaultAllow = true
+	expect(t, serveAuthorization(t, e, "GET", "/users/1/posts", "user"), http.StatusOK)
+	expect(t, serveAuthorization(t, e, "GET", "/users/1/posts"), http.StatusUnauthorized)
+}
+
+func Test_AuthorizationFromFile(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "rules.json")
+	rules := `[{"method": "GET", "path": "/", "public": true}, {"path": "/admin/*", "roles": ["admin"]}]`
+	if err := os.WriteFile(name, []byte(rules), 0600); err != nil {
+		t.Fatal(err)
+	}
+	authz, err := NewAuthorizationFromFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := New(withTestPrincipal(), authz)
+	e.PushHandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	expect(t, serveAuthorization(t, e, "GET", "/"), http.StatusOK)
+	expect(t, serveAuthorization(t, e, "GET", "/admin/settings", "user"), http.StatusForbidden)
+	expect(t, serveAuthorization(t, e, "GET", "/admin/settings", "admin"), http.StatusOK)
+}
+
+func Test_RequireRoles(t *testing.T) {
+	router := httprouter.New()
+	router.DELETE("/users/:id", New(withTestPrincipal(), RequireRoles("admin"), UseHTTPRouterHandler(
+		func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+			w.Write([]byte(ps.ByName("id")))
+		})).ForHTTPRouter())
+	router.GET("/orders", New(withTestPrincipal(), RequireScopes("orders:read")).ForHTTPRouter())
+	serve := func(method string, path string, roles ...string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, "http://localhost:8384"+path, nil)
+		if err != nil {
+			t.Error(err)
+		}
+		if roles != nil {
+			req.Header["X-Roles"] = roles
+		}
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		return recorder
+	}
+	expect(t, serve("DELETE", "/users/1").Code, http.StatusUnauthorized)
+	expect(t, serve("DELETE", "/users/1", "user").Code, http.StatusForbidden)
+	allowed := serve("DELETE", "/users/1", "admin")
+	expect(t, allowed.Code, http.StatusOK)
+	expect(t, allowed.Body.String(), "1")
+	expect(t, serve("GET", "/orders", "orders:read").Code, http.StatusOK)
+}
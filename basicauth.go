@@ -2,28 +2,112 @@ package entre
 
 import (
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
 )
 
+// CredentialStore checks user credentials for the authentication middleware.
+type CredentialStore interface {
+	Authenticate(user string, password string) bool
+}
+
+// DigestStore provides users' HA1 hashes for DigestAuth, the hash of "user:realm:password"
+// with the provided algorithm, so it can check credentials without the password being sent.
+type DigestStore interface {
+	HA1(user string, realm string, algorithm string) (string, bool)
+}
+
+// Users is a simple in-memory credential store mapping usernames to plaintext passwords.
+type Users map[string]string
+
+// Authenticate checks the password of the provided user in constant time.
+func (u Users) Authenticate(user string, password string) bool {
+	expected, ok := u[user]
+	// The comparison is made for unknown users too so their timing doesn't differ.
+	return secureCompare(password, expected) && ok
+}
+
+// HA1 provides the HA1 hash of the provided user for digest authentication.
+func (u Users) HA1(user string, realm string, algorithm string) (string, bool) {
+	password, ok := u[user]
+	h := digestHash(algorithm)
+	if !ok || h == nil {
+		return "", false
+	}
+	return digestHex(h, user+":"+realm+":"+password), true
+}
+
 // BasicAuth provides the basic authentication middleware.
 type BasicAuth struct {
-	user     string
-	password string
+	// Realm is the protection space sent to clients in the WWW-Authenticate header.
+	Realm string
+	// Store checks the credentials provided by clients.
+	Store CredentialStore
+	// Lockout throttles repeated failures per client IP and per username, when set.
+	// Locked out clients are responded to with a 429 Too Many Requests.
+	Lockout *Lockout
+	// OnFailure is called for each failed attempt, when set.
+	OnFailure func(r *http.Request, failure *AuthFailure)
 }
 
 // NewBasicAuth creates a new basic auth instance with the provided
 // username and password.
 func NewBasicAuth(user string, password string) *BasicAuth {
-	return &BasicAuth{user, password}
+	return NewBasicAuthWithStore(Users{user: password})
+}
+
+// NewBasicAuthWithStore creates a new basic auth instance which checks
+// credentials against the provided store, such as an Htpasswd file.
+func NewBasicAuthWithStore(store CredentialStore) *BasicAuth {
+	return &BasicAuth{Realm: "Restricted", Store: store}
 }
 
 func (b *BasicAuth) ServeHTTP(w http.ResponseWriter, r *http.Request, ps httprouter.Params, next http.HandlerFunc) {
 	usr, pass, hasAuth := r.BasicAuth()
-	if hasAuth && usr == b.user && pass == b.password {
-		next(w, r)
-	} else {
-		w.Header().Set("WWW-Authenticate", "Basic realm=Restricted")
-		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	if !hasAuth {
+		b.challenge(w)
+		return
+	}
+	keys := []string{"ip:" + ClientIPFrom(r), "user:" + usr}
+	if b.Lockout != nil {
+		// Credentials aren't checked at all while locked out, even correct ones.
+		if remaining := b.Lockout.Locked(keys...); remaining > 0 {
+			b.fail(r, usr, "locked out", remaining)
+			tooManyRequests(w, remaining)
+			return
+		}
+	}
+	if b.Store != nil && b.Store.Authenticate(usr, pass) {
+		if b.Lockout != nil {
+			b.Lockout.Reset(keys[1])
+		}
+		next(w, WithPrincipal(r, &Principal{ID: usr, Name: usr, AuthMethod: "basic"}))
+		return
 	}
+	var remaining time.Duration
+	if b.Lockout != nil {
+		remaining = b.Lockout.Fail(keys...)
+	}
+	b.fail(r, usr, "invalid credentials", remaining)
+	b.challenge(w)
+}
+
+func (b *BasicAuth) challenge(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", "Basic realm="+strconv.Quote(b.Realm)+`, charset="UTF-8"`)
+	http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+}
+
+func (b *BasicAuth) fail(r *http.Request, usr string, reason string, lockedFor time.Duration) {
+	if b.OnFailure == nil {
+		return
+	}
+	b.OnFailure(r, newAuthFailure(r, usr, reason, lockedFor))
+}
+
+// tooManyRequests responds with a 429 telling the client when to retry.
+func tooManyRequests(w http.ResponseWriter, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int((retryAfter+time.Second-1)/time.Second)))
+	http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
 }
@@ -4,6 +4,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 func Test_BasicAuth(t *testing.T) {
@@ -28,3 +29,57 @@ func Test_BasicAuth(t *testing.T) {
 	e.ServeHTTP(recorder, req)
 	expect(t, recorder.Code, http.StatusNotFound)
 }
+
+func Test_BasicAuthRealm(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	ba := NewBasicAuthWithStore(Users{"user": "password", "other": "secret"})
+	ba.Realm = "Admin area"
+	e := New(ba)
+	req, err := http.NewRequest("GET", "http://localhost:8384/test", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	e.ServeHTTP(recorder, req)
+	expect(t, recorder.Code, http.StatusUnauthorized)
+	expect(t, recorder.Header().Get("WWW-Authenticate"), `Basic realm="Admin area", charset="UTF-8"`)
+
+	req.SetBasicAuth("other", "secret")
+	recorder = httptest.NewRecorder()
+	e.ServeHTTP(recorder, req)
+	expect(t, recorder.Code, http.StatusOK)
+}
+
+func Test_BasicAuthLockout(t *testing.T) {
+	var failures []*AuthFailure
+	ba := NewBasicAuth("user", "password")
+	ba.Lockout = NewLockout(2)
+	ba.Lockout.BaseDelay = time.Minute
+	ba.OnFailure = func(r *http.Request, failure *AuthFailure) {
+		failures = append(failures, failure)
+	}
+	e := New(ba)
+	serve := func(password string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("GET", "http://localhost:8384/test", nil)
+		if err != nil {
+			t.Error(err)
+		}
+		req.RemoteAddr = "192.0.2.1:1234"
+		req.SetBasicAuth("user", password)
+		recorder := httptest.NewRecorder()
+		e.ServeHTTP(recorder, req)
+		return recorder
+	}
+	expect(t, serve("wrong").Code, http.StatusUnauthorized)
+	expect(t, serve("wrong").Code, http.StatusUnauthorized)
+	// Even the correct password is refused while locked out.
+	locked := serve("password")
+	expect(t, locked.Code, http.StatusTooManyRequests)
+	expect(t, locked.Header().Get("Retry-After"), "60")
+
+	expect(t, len(failures), 3)
+	expect(t, failures[0].User, "user")
+	expect(t, failures[0].RemoteIP, "192.0.2.1")
+	expect(t, failures[0].Reason, "invalid credentials")
+	expect(t, failures[2].Reason, "locked out")
+	refute(t, failures[2].LockedFor, time.Duration(0))
+}
@@ -0,0 +1,265 @@
+package entre
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// BodyLogging configures the opt-in request and response body logging
+// of the logging middleware. It is meant for debugging integrations,
+// everything that is logged goes through the configured redaction rules first.
+type BodyLogging struct {
+	// MaxBytes caps how much of each body is captured and logged.
+	MaxBytes int
+	// ContentTypes is the allowlist of media types bodies are logged for,
+	// entries can contain wildcards such as "application/*+json".
+	ContentTypes []string
+	// RedactHeaders lists the request and response headers whose values are never logged.
+	RedactHeaders []string
+	// RedactQuery lists the query parameters whose values are never logged.
+	RedactQuery []string
+	// RedactJSONFields lists the JSON object keys whose values are never logged,
+	// these are matched at any depth.
+	RedactJSONFields []string
+	// RedactFormFields lists the url encoded form fields whose values are never logged.
+	RedactFormFields []string
+	// Replacement is what redacted values are replaced with.
+	Replacement string
+}
+
+// NewBodyLogging creates body logging configuration with sensible size caps
+// and redaction rules for the most common kinds of secrets.
+func NewBodyLogging() *BodyLogging {
+	secretFields := []string{
+		"password", "passwd", "pass", "secret", "client_secret", "token", "access_token",
+		"refresh_token", "id_token", "api_key", "apikey", "authorization", "credit_card",
+		"card_number", "cvv",
+	}
+	return &BodyLogging{
+		MaxBytes: 4096,
+		ContentTypes: []string{
+			"application/json",
+			"application/*+json",
+			"application/x-www-form-urlencoded",
+		},
+		RedactHeaders: []string{
+			"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-Api-Key", "X-Auth-Token",
+		},
+		RedactQuery:      append([]string{"key", "sig", "signature"}, secretFields...),
+		RedactJSONFields: secretFields,
+		RedactFormFields: secretFields,
+		Replacement:      "[REDACTED]",
+	}
+}
+
+// captureRequestBody reads up to MaxBytes of the request body for logging
+// and restores the body so downstream handlers can still read it in full.
+func (b *BodyLogging) captureRequestBody(r *http.Request) ([]byte, bool) {
+	if r.Body == nil || r.Body == http.NoBody || !b.allowed(r.Header.Get("Content-Type")) {
+		return nil, false
+	}
+	captured, err := io.ReadAll(io.LimitReader(r.Body, int64(b.MaxBytes)+1))
+	r.Body = readCloser{io.MultiReader(bytes.NewReader(captured), r.Body), r.Body}
+	if err != nil {
+		return nil, false
+	}
+	return captured, true
+}
+
+// allowed determines whether bodies of the provided content type can be logged.
+func (b *BodyLogging) allowed(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, pattern := range b.ContentTypes {
+		if ok, _ := path.Match(strings.ToLower(pattern), mediaType); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// formatHeaders renders headers for logging in a stable order with sensitive values redacted.
+func (b *BodyLogging) formatHeaders(h http.Header) string {
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		value := strings.Join(h[name], ", ")
+		if containsFold(b.RedactHeaders, name) {
+			value = b.Replacement
+		}
+		parts = append(parts, fmt.Sprintf("%s=%q", name, value))
+	}
+	return strings.Join(parts, " ")
+}
+
+// formatQuery renders a raw query string for logging with sensitive parameters redacted.
+func (b *BodyLogging) formatQuery(rawQuery string) string {
+	values, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return "[query omitted: unable to parse for redaction]"
+	}
+	return b.redactValues(values, b.RedactQuery)
+}
+
+// formatBody renders a captured body for logging, applying the redaction rules
+// for the body's content type. Bodies which can't be parsed for redaction are omitted
+// rather than risking secrets ending up in the logs.
+func (b *BodyLogging) formatBody(contentType string, body []byte) string {
+	truncated := len(body) > b.MaxBytes
+	if truncated {
+		body = body[:b.MaxBytes]
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	var out string
+	switch {
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		if truncated {
+			return fmt.Sprintf("[%s body omitted: larger than %d bytes]", mediaType, b.MaxBytes)
+		}
+		redacted, err := b.redactJSON(body)
+		if err != nil {
+			return fmt.Sprintf("[%s body omitted: unable to parse for redaction]", mediaType)
+		}
+		out = redacted
+	case mediaType == "application/x-www-form-urlencoded":
+		values, err := url.ParseQuery(string(body))
+		if err != nil {
+			return fmt.Sprintf("[%s body omitted: unable to parse for redaction]", mediaType)
+		}
+		out = b.redactValues(values, b.RedactFormFields)
+	default:
+		// Other bodies are quoted like headers are, so they can't forge log lines either.
+		if truncated {
+			// Don't leave a character cut in half at the limit.
+			for i := 0; i < utf8.UTFMax-1 && len(body) > 0 && !utf8.Valid(body); i++ {
+				body = body[:len(body)-1]
+			}
+		}
+		if !utf8.Valid(body) {
+			return fmt.Sprintf("[%s body omitted: not valid UTF-8]", mediaType)
+		}
+		out = strconv.Quote(string(body))
+	}
+	if truncated {
+		out += " [truncated]"
+	}
+	return out
+}
+
+// redactValues renders values in their encoded form with sensitive ones replaced, so control
+// characters such as newlines sent by clients can't forge log lines. Only the replacement is left unescaped.
+func (b *BodyLogging) redactValues(values url.Values, fields []string) string {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var out strings.Builder
+	for _, name := range names {
+		for _, value := range values[name] {
+			if out.Len() > 0 {
+				out.WriteByte('&')
+			}
+			out.WriteString(url.QueryEscape(name) + "=")
+			if containsFold(fields, name) {
+				out.WriteString(b.Replacement)
+				break
+			}
+			out.WriteString(url.QueryEscape(value))
+		}
+	}
+	return out.String()
+}
+
+func (b *BodyLogging) redactJSON(body []byte) (string, error) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return "", err
+	}
+	if dec.More() {
+		return "", fmt.Errorf("unexpected content after JSON value")
+	}
+	out, err := json.Marshal(b.redactJSONValue(v))
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+func (b *BodyLogging) redactJSONValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for key, item := range val {
+			if containsFold(b.RedactJSONFields, key) {
+				val[key] = b.Replacement
+			} else {
+				val[key] = b.redactJSONValue(item)
+			}
+		}
+	case []interface{}:
+		for i, item := range val {
+			val[i] = b.redactJSONValue(item)
+		}
+	}
+	return v
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// bodyCaptureResponse captures the start of a response body for logging
+// as it is written to the underlying response.
+type bodyCaptureResponse struct {
+	Response
+	max int
+	buf bytes.Buffer
+}
+
+func (c *bodyCaptureResponse) Write(b []byte) (int, error) {
+	if remaining := c.max + 1 - c.buf.Len(); remaining > 0 {
+		if len(b) < remaining {
+			remaining = len(b)
+		}
+		c.buf.Write(b[:remaining])
+	}
+	return c.Response.Write(b)
+}
+
+func (c *bodyCaptureResponse) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := c.Response.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("the ResponseWriter doesn't support hijacking")
+	}
+	return hijacker.Hijack()
+}
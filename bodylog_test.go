@@ -0,0 +1,123 @@
+package entre
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_BodyLoggingAllowed(t *testing.T) {
+	b := NewBodyLogging()
+	expect(t, b.allowed("application/json; charset=utf-8"), true)
+	expect(t, b.allowed("application/problem+json"), true)
+	expect(t, b.allowed("application/x-www-form-urlencoded"), true)
+	expect(t, b.allowed("text/html"), false)
+	expect(t, b.allowed(""), false)
+}
+
+func Test_BodyLoggingUnparseableJSON(t *testing.T) {
+	b := NewBodyLogging()
+	// Bodies we can't parse for redaction must never be logged as they are.
+	expect(t, b.formatBody("application/json", []byte(`{"password":"secret"`)), "[application/json body omitted: unable to parse for redaction]")
+	b.MaxBytes = 8
+	expect(t, b.formatBody("application/json", []byte(`{"password":"secret"}`)), "[application/json body omitted: larger than 8 bytes]")
+}
+
+func Test_BodyLoggingControlCharacters(t *testing.T) {
+	b := NewBodyLogging()
+	// Encoded newlines are logged encoded so clients can't forge log lines.
+	expect(t, b.formatQuery("q=a%0Afake+log+line&token=x%0Ay"), "q=a%0Afake+log+line&token=[REDACTED]")
+	expect(t, b.formatBody("application/x-www-form-urlencoded", []byte("name=a%0D%0Afake")), "name=a%0D%0Afake")
+}
+
+func Test_BodyLoggingHeaders(t *testing.T) {
+	b := NewBodyLogging()
+	for _, test := range []struct {
+		header   http.Header
+		expected string
+	}{
+		{http.Header{"Accept": {"text/html"}}, `Accept="text/html"`},
+		{http.Header{"Authorization": {"Bearer secret"}}, `Authorization="[REDACTED]"`},
+		{http.Header{"X-Api-Key": {"secret"}, "Accept": {"*/*"}}, `Accept="*/*" X-Api-Key="[REDACTED]"`},
+		{http.Header{"X-Forwarded-For": {"10.0.0.1", "10.0.0.2"}}, `X-Forwarded-For="10.0.0.1, 10.0.0.2"`},
+		// Header values are quoted so they can't forge log lines.
+		{http.Header{"User-Agent": {"agent\nfake"}}, `User-Agent="agent\nfake"`},
+	} {
+		expect(t, b.formatHeaders(test.header), test.expected)
+	}
+}
+
+func Test_BodyLoggingQuery(t *testing.T) {
+	b := NewBodyLogging()
+	for _, test := range []struct {
+		query    string
+		expected string
+	}{
+		{"page=2&sort=name", "page=2&sort=name"},
+		{"api_key=secret&page=2", "api_key=[REDACTED]&page=2"},
+		{"TOKEN=secret", "TOKEN=[REDACTED]"},
+		{"sig=a&sig=b", "sig=[REDACTED]"},
+		{"tag=a&tag=b", "tag=a&tag=b"},
+		{"q=a+b%26c", "q=a+b%26c"},
+		{"q=%zz", "[query omitted: unable to parse for redaction]"},
+	} {
+		expect(t, b.formatQuery(test.query), test.expected)
+	}
+}
+
+func Test_BodyLoggingFormatBody(t *testing.T) {
+	for _, test := range []struct {
+		contentType string
+		body        string
+		maxBytes    int
+		expected    string
+	}{
+		{"application/json", `{"id":1,"password":"secret"}`, 4096, `{"id":1,"password":"[REDACTED]"}`},
+		{"application/problem+json", `[{"token":"secret"}]`, 4096, `[{"token":"[REDACTED]"}]`},
+		{"application/x-www-form-urlencoded", "name=jane&password=secret", 4096, "name=jane&password=[REDACTED]"},
+		{"application/x-www-form-urlencoded", "name=jane&password=secret", 20, "name=jane&password=[REDACTED] [truncated]"},
+		{"text/plain", "line one\nline two", 4096, `"line one\nline two"`},
+		{"text/plain", "a longer body", 8, `"a longer" [truncated]`},
+		// Characters cut in half at the limit are left out.
+		{"text/plain", "café au lait", 4, `"caf" [truncated]`},
+		{"application/octet-stream", "\x00\xff\xfe binary", 4096, "[application/octet-stream body omitted: not valid UTF-8]"},
+		{"text/plain; charset=iso-8859-1", "caf\xe9", 4096, "[text/plain body omitted: not valid UTF-8]"},
+	} {
+		b := NewBodyLogging()
+		b.MaxBytes = test.maxBytes
+		expect(t, b.formatBody(test.contentType, []byte(test.body)), test.expected)
+	}
+}
+
+func Test_BodyLoggingCaptureRequestBody(t *testing.T) {
+	for _, test := range []struct {
+		contentType string
+		body        string
+		captured    string
+		ok          bool
+	}{
+		{"application/json", `{"a":1}`, `{"a":1}`, true},
+		{"application/json", "0123456789", "0123456789", true},
+		// One byte past the limit is captured so the body is known to be truncated.
+		{"application/json", "0123456789abcdef", "0123456789a", true},
+		{"text/plain", "not in the allowlist", "", false},
+		{"application/json", "", "", false},
+	} {
+		b := NewBodyLogging()
+		b.MaxBytes = 10
+		req := httptest.NewRequest("POST", "/", strings.NewReader(test.body))
+		if test.body == "" {
+			req.Body = http.NoBody
+		}
+		req.Header.Set("Content-Type", test.contentType)
+		captured, ok := b.captureRequestBody(req)
+		expect(t, ok, test.ok)
+		expect(t, string(captured), test.captured)
+		// The next handler still gets the whole body.
+		body, err := io.ReadAll(req.Body)
+		expect(t, err, nil)
+		expect(t, string(body), test.body)
+	}
+}
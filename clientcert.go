@@ -0,0 +1,158 @@
+package entre
+
+import (
+	"crypto/x509"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// CertRule allows the client certificate identities matching its patterns to access
+// the requests matching its method and path pattern, see Rule for the path patterns supported.
+type CertRule struct {
+	// Method is the HTTP method the rule applies to, any method when empty or "*".
+	Method string `json:"method,omitempty"`
+	// Path is the path pattern the rule applies to.
+	Path string `json:"path"`
+	// Identities are the identities allowed, a trailing "*" matches any suffix
+	// such as "spiffe://example.org/ns/billing/*".
+	Identities []string `json:"identities"`
+}
+
+func (rule *CertRule) allows(identity string) bool {
+	for _, pattern := range rule.Identities {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok && strings.HasPrefix(identity, prefix) {
+			return true
+		}
+		if pattern == identity {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientCertAuth provides the mutual TLS client certificate authentication middleware.
+// The client certificate must have been verified, either by the server's TLS config
+// with ClientAuth set to tls.VerifyClientCertIfGiven or tls.RequireAndVerifyClientCert,
+// or by the middleware against Roots. The identity of the certificate is its SPIFFE ID URI SAN,
+// otherwise its first DNS SAN, otherwise its first email SAN, otherwise its subject common name.
+// Requests without a verified certificate are responded to with a 401 Unauthorized
+// and those for which the identity isn't allowed by the Rules with a 403 Forbidden.
+type ClientCertAuth struct {
+	// Rules are checked in order and the first rule matching the request decides which
+	// identities are allowed. Every identity is allowed when there are no rules,
+	// otherwise requests matching no rule are forbidden.
+	Rules []*CertRule
+	// Roots verifies client certificates which the server requested but didn't verify,
+	// when set.
+	Roots *x509.CertPool
+	// OnFailure is called for each failed attempt, when set.
+	OnFailure func(r *http.Request, failure *AuthFailure)
+}
+
+// NewClientCertAuth creates a new client certificate auth instance with the provided rules.
+func NewClientCertAuth(rules ...*CertRule) *ClientCertAuth {
+	return &ClientCertAuth{Rules: rules}
+}
+
+func (c *ClientCertAuth) ServeHTTP(w http.ResponseWriter, r *http.Request, ps httprouter.Params, next http.HandlerFunc) {
+	cert := c.verifiedCert(r)
+	if cert == nil {
+		reason := "missing client certificate"
+		if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+			reason = "unverified client certificate"
+		}
+		c.fail(r, "", reason)
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	p := certPrincipal(cert)
+	if !c.allows(r, p.ID) {
+		c.fail(r, p.ID, "identity not allowed")
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	next(w, WithPrincipal(r, p))
+}
+
+// verifiedCert provides the client's leaf certificate when it has been verified.
+func (c *ClientCertAuth) verifiedCert(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return nil
+	}
+	if len(r.TLS.VerifiedChains) > 0 {
+		return r.TLS.VerifiedChains[0][0]
+	}
+	if c.Roots == nil {
+		return nil
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range r.TLS.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	leaf := r.TLS.PeerCertificates[0]
+	_, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         c.Roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	if err != nil {
+		return nil
+	}
+	return leaf
+}
+
+func (c *ClientCertAuth) allows(r *http.Request, identity string) bool {
+	if len(c.Rules) == 0 {
+		return true
+	}
+	for _, rule := range c.Rules {
+		if (&Rule{Method: rule.Method, Path: rule.Path}).matches(r.Method, r.URL.Path) {
+			return rule.allows(identity)
+		}
+	}
+	return false
+}
+
+func (c *ClientCertAuth) fail(r *http.Request, identity string, reason string) {
+	if c.OnFailure != nil {
+		c.OnFailure(r, newAuthFailure(r, identity, reason, 0))
+	}
+}
+
+// certPrincipal creates a principal from a client certificate.
+func certPrincipal(cert *x509.Certificate) *Principal {
+	claims := map[string]interface{}{
+		"subject": cert.Subject.String(),
+		"issuer":  cert.Issuer.String(),
+		"serial":  cert.SerialNumber.String(),
+	}
+	if len(cert.DNSNames) > 0 {
+		claims["dns_names"] = cert.DNSNames
+	}
+	if len(cert.EmailAddresses) > 0 {
+		claims["emails"] = cert.EmailAddresses
+	}
+	p := &Principal{Name: cert.Subject.CommonName, Claims: claims, AuthMethod: "mtls"}
+	for _, uri := range cert.URIs {
+		if uri.Scheme == "spiffe" {
+			p.ID = uri.String()
+			claims["spiffe_id"] = p.ID
+			break
+		}
+	}
+	switch {
+	case p.ID != "":
+	case len(cert.DNSNames) > 0:
+		p.ID = cert.DNSNames[0]
+	case len(cert.EmailAddresses) > 0:
+		p.ID = cert.EmailAddresses[0]
+	default:
+		p.ID = cert.Subject.CommonName
+	}
+	if p.Name == "" {
+		p.Name = p.ID
+	}
+	return p
+}
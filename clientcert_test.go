@@ -0,0 +1,149 @@
+package entre
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key}
+}
+
+func (ca *testCA) pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	return pool
+}
+
+// issue issues a client certificate with the provided common name and SANs.
+func (ca *testCA) issue(t *testing.T, cn string, dnsNames []string, uris ...string) tls.Certificate {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		DNSNames:     dnsNames,
+	}
+	for _, u := range uris {
+		parsed, _ := url.Parse(u)
+		template.URIs = append(template.URIs, parsed)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func newClientCertServer(t *testing.T, clientAuth tls.ClientAuthType, ca *testCA, auth *ClientCertAuth) *httptest.Server {
+	e := New(auth)
+	e.PushHandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := PrincipalFrom(r)
+		fmt.Fprintf(w, "%s|%s|%s", p.ID, p.Name, p.AuthMethod)
+	})
+	server := httptest.NewUnstartedServer(e)
+	server.TLS = &tls.Config{ClientAuth: clientAuth, ClientCAs: ca.pool()}
+	server.StartTLS()
+	return server
+}
+
+func clientCertGet(t *testing.T, server *httptest.Server, path string, certs ...tls.Certificate) (int, string) {
+	// A new transport is used for every request so connections made with other certificates aren't reused.
+	transport := server.Client().Transport.(*http.Transport).Clone()
+	transport.TLSClientConfig.Certificates = certs
+	defer transport.CloseIdleConnections()
+	resp, err := (&http.Client{Transport: transport}).Get(server.URL + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body := make([]byte, 512)
+	n, _ := resp.Body.Read(body)
+	return resp.StatusCode, string(body[:n])
+}
+
+func Test_ClientCertAuth(t *testing.T) {
+	ca := newTestCA(t)
+	var failures []*AuthFailure
+	auth := NewClientCertAuth(
+		&CertRule{Path: "/billing/*", Identities: []string{"spiffe://example.org/ns/billing/*"}},
+		&CertRule{Path: "/*", Identities: []string{"spiffe://example.org/*", "reports.internal"}},
+	)
+	auth.OnFailure = func(r *http.Request, failure *AuthFailure) {
+		failures = append(failures, failure)
+	}
+	server := newClientCertServer(t, tls.VerifyClientCertIfGiven, ca, auth)
+	defer server.Close()
+
+	billing := ca.issue(t, "billing", nil, "spiffe://example.org/ns/billing/sa/api")
+	reports := ca.issue(t, "", []string{"reports.internal"})
+
+	status, body := clientCertGet(t, server, "/billing/invoices", billing)
+	expect(t, status, http.StatusOK)
+	expect(t, body, "spiffe://example.org/ns/billing/sa/api|billing|mtls")
+
+	status, body = clientCertGet(t, server, "/reports", reports)
+	expect(t, status, http.StatusOK)
+	expect(t, body, "reports.internal|reports.internal|mtls")
+
+	status, _ = clientCertGet(t, server, "/billing/invoices", reports)
+	expect(t, status, http.StatusForbidden)
+
+	status, _ = clientCertGet(t, server, "/reports")
+	expect(t, status, http.StatusUnauthorized)
+	expect(t, len(failures), 2)
+	expect(t, failures[0].Reason, "identity not allowed")
+	expect(t, failures[0].User, "reports.internal")
+	expect(t, failures[1].Reason, "missing client certificate")
+}
+
+func Test_ClientCertAuthRoots(t *testing.T) {
+	ca := newTestCA(t)
+	other := newTestCA(t)
+	// The server requests certificates without verifying them so the middleware does.
+	auth := NewClientCertAuth()
+	auth.Roots = ca.pool()
+	server := newClientCertServer(t, tls.RequestClientCert, ca, auth)
+	defer server.Close()
+
+	status, body := clientCertGet(t, server, "/", ca.issue(t, "service", nil))
+	expect(t, status, http.StatusOK)
+	expect(t, body, "service|service|mtls")
+
+	status, _ = clientCertGet(t, server, "/", other.issue(t, "intruder", nil))
+	expect(t, status, http.StatusUnauthorized)
+}
@@ -0,0 +1,259 @@
+package entre
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+type csrfKey struct{}
+
+// csrfState is what the CSRF middleware stores in the request context.
+type csrfState struct {
+	secret    []byte
+	formField string
+}
+
+// csrfSessionKey is the session value the synchronizer token is kept under.
+const csrfSessionKey = "_csrf"
+
+const csrfTokenLength = 32
+
+// Errors requests are rejected by the CSRF middleware with.
+var (
+	ErrCSRFOrigin = errors.New("request origin not allowed")
+	ErrCSRFToken  = errors.New("missing or invalid CSRF token")
+)
+
+// CSRF provides the cross-site request forgery protection middleware.
+// When the request has a session, see Sessions, the token is kept in the session following the
+// synchronizer token pattern, otherwise it is kept in a cookie signed with Key following the double-submit
+// cookie pattern.
+// Requests with unsafe methods must come from an allowed origin according to their Origin or Referer
+// header and must send the token back in the Header or the FormField, otherwise they are responded to
+// with a 403 Forbidden. The token is masked differently for every request so it can't be recovered
+// through compression side channels, see CSRFToken and CSRFField.
+type CSRF struct {
+	// Key signs the double-submit cookie with HMAC-SHA256, so cookies planted by other sites
+	// sharing the domain, such as sibling subdomains, are rejected.
+	Key []byte
+	// CookieName is the name of the double-submit cookie.
+	CookieName string
+	// Header is the header unsafe requests can send the token in.
+	Header string
+	// FormField is the form field unsafe requests can send the token in.
+	FormField string
+	// TrustedOrigins are the origins allowed besides the request's own, such as "https://admin.example.com".
+	TrustedOrigins []string
+	// TrustedProxies are the networks of the proxies trusted to forward the scheme of the request's
+	// own origin in the X-Forwarded-Proto header, such as proxies terminating TLS.
+	TrustedProxies []netip.Prefix
+	// ExemptPaths are path patterns not protected, see Rule for the patterns supported.
+	ExemptPaths []string
+	// ExemptBearer exempts requests with a bearer Authorization header on every path, which browsers
+	// never add on their own so such requests can't be forged. ExemptPaths can exempt API routes instead.
+	ExemptBearer bool
+	// Secure and SameSite are attributes of the double-submit cookie.
+	Secure   bool
+	SameSite http.SameSite
+	// OnFailure is called with the reason for each rejected request, when set.
+	OnFailure func(r *http.Request, err error)
+}
+
+// NewCSRF creates a new CSRF protection instance signing double-submit cookies with the provided key,
+// taking the token from the X-CSRF-Token header or the csrf_token form field. It panics when the key is
+// shorter than 32 bytes.
+func NewCSRF(key []byte) *CSRF {
+	if len(key) < csrfTokenLength {
+		panic(fmt.Sprintf("The CSRF key must be at least %d bytes long, not %d", csrfTokenLength, len(key)))
+	}
+	return &CSRF{
+		Key:        key,
+		CookieName: "csrf_token",
+		Header:     "X-CSRF-Token",
+		FormField:  "csrf_token",
+		Secure:     true,
+		SameSite:   http.SameSiteLaxMode,
+	}
+}
+
+func (c *CSRF) ServeHTTP(w http.ResponseWriter, r *http.Request, ps httprouter.Params, next http.HandlerFunc) {
+	if c.exempt(r) {
+		next(w, r)
+		return
+	}
+	secret := c.secret(w, r)
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+	default:
+		if err := c.verify(r, secret); err != nil {
+			if c.OnFailure != nil {
+				c.OnFailure(r, err)
+			}
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+	}
+	next(w, r.WithContext(context.WithValue(r.Context(), csrfKey{}, &csrfState{secret: secret, formField: c.FormField})))
+}
+
+func (c *CSRF) exempt(r *http.Request) bool {
+	if c.ExemptBearer {
+		if scheme, _, ok := strings.Cut(r.Header.Get("Authorization"), " "); ok && strings.EqualFold(scheme, "Bearer") {
+			return true
+		}
+	}
+	for _, path := range c.ExemptPaths {
+		if (&Rule{Path: path}).matches(r.Method, r.URL.Path) {
+			return true
+		}
+	}
+	return false
+}
+
+// secret provides the request's CSRF secret, generating and storing a new one when there isn't one.
+func (c *CSRF) secret(w http.ResponseWriter, r *http.Request) []byte {
+	session := SessionFrom(r)
+	if session != nil {
+		if encoded, ok := session.Get(csrfSessionKey).(string); ok {
+			if secret, err := base64.RawURLEncoding.DecodeString(encoded); err == nil && len(secret) == csrfTokenLength {
+				return secret
+			}
+		}
+	} else if cookie, err := r.Cookie(c.CookieName); err == nil {
+		decoded, err := base64.RawURLEncoding.DecodeString(cookie.Value)
+		if err == nil && len(decoded) == csrfTokenLength+sha256.Size &&
+			hmac.Equal(decoded[csrfTokenLength:], c.sign(decoded[:csrfTokenLength])) {
+			return decoded[:csrfTokenLength]
+		}
+	}
+	secret := make([]byte, csrfTokenLength)
+	rand.Read(secret)
+	if session != nil {
+		session.Set(csrfSessionKey, base64.RawURLEncoding.EncodeToString(secret))
+	} else {
+		http.SetCookie(w, &http.Cookie{
+			Name:     c.CookieName,
+			Value:    base64.RawURLEncoding.EncodeToString(append(secret, c.sign(secret)...)),
+			Path:     "/",
+			Secure:   c.Secure,
+			HttpOnly: true,
+			SameSite: c.SameSite,
+		})
+	}
+	return secret
+}
+
+// sign provides the signature of a double-submit cookie secret, which covers the cookie name too.
+func (c *CSRF) sign(secret []byte) []byte {
+	mac := hmac.New(sha256.New, c.Key)
+	mac.Write([]byte(c.CookieName + "\x00"))
+	mac.Write(secret)
+	return mac.Sum(nil)
+}
+
+// verify checks the origin of an unsafe request and the token it sent.
+func (c *CSRF) verify(r *http.Request, secret []byte) error {
+	origin := r.Header.Get("Origin")
+	if origin == "" || origin == "null" {
+		origin = r.Header.Get("Referer")
+	}
+	if origin != "" && !c.allowedOrigin(r, origin) {
+		return ErrCSRFOrigin
+	}
+	token := r.Header.Get(c.Header)
+	if token == "" && c.FormField != "" {
+		token = r.PostFormValue(c.FormField)
+	}
+	if !validCSRFToken(token, secret) {
+		return ErrCSRFToken
+	}
+	return nil
+}
+
+func (c *CSRF) allowedOrigin(r *http.Request, origin string) bool {
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	if strings.EqualFold(u.Scheme, c.scheme(r)) && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, trusted := range c.TrustedOrigins {
+		if t, err := url.Parse(trusted); err == nil && strings.EqualFold(t.Scheme, u.Scheme) && strings.EqualFold(t.Host, u.Host) {
+			return true
+		}
+	}
+	return false
+}
+
+// scheme provides the scheme of the request's own origin, taken from the last X-Forwarded-Proto
+// value when the request comes from one of the TrustedProxies.
+func (c *CSRF) scheme(r *http.Request) string {
+	if len(c.TrustedProxies) > 0 {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		addr, err := netip.ParseAddr(host)
+		if err == nil && containsAddr(c.TrustedProxies, addr.Unmap().WithZone("")) {
+			if values := r.Header.Values("X-Forwarded-Proto"); len(values) > 0 {
+				protos := strings.Split(values[len(values)-1], ",")
+				return strings.ToLower(strings.TrimSpace(protos[len(protos)-1]))
+			}
+		}
+	}
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// CSRFToken provides the CSRF token to include in forms or send in the CSRF header
+// for the provided request, an empty string when it isn't being served by the CSRF middleware.
+func CSRFToken(r *http.Request) string {
+	state, ok := r.Context().Value(csrfKey{}).(*csrfState)
+	if !ok {
+		return ""
+	}
+	mask := make([]byte, len(state.secret))
+	rand.Read(mask)
+	masked := make([]byte, len(state.secret))
+	subtle.XORBytes(masked, mask, state.secret)
+	return base64.RawURLEncoding.EncodeToString(append(mask, masked...))
+}
+
+// CSRFField provides a hidden form field holding the CSRF token for use in templates.
+//
+//	<form method="post">{{ .CSRFField }}</form>
+func CSRFField(r *http.Request) template.HTML {
+	state, ok := r.Context().Value(csrfKey{}).(*csrfState)
+	if !ok {
+		return ""
+	}
+	return template.HTML(`<input type="hidden" name="` + template.HTMLEscapeString(state.formField) +
+		`" value="` + template.HTMLEscapeString(CSRFToken(r)) + `">`)
+}
+
+func validCSRFToken(token string, secret []byte) bool {
+	decoded, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(decoded) != 2*len(secret) {
+		return false
+	}
+	unmasked := make([]byte, len(secret))
+	subtle.XORBytes(unmasked, decoded[:len(secret)], decoded[len(secret):])
+	return subtle.ConstantTimeCompare(unmasked, secret) == 1
+}
@@ -0,0 +1,158 @@
+package entre
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var testCSRFKey = []byte("fedcba9876543210fedcba9876543210")
+
+func Test_CSRF(t *testing.T) {
+	var failures []error
+	csrf := NewCSRF(testCSRFKey)
+	csrf.TrustedOrigins = []string{"https://admin.example.com"}
+	csrf.ExemptPaths = []string{"/webhooks/*"}
+	csrf.OnFailure = func(r *http.Request, err error) {
+		failures = append(failures, err)
+	}
+	e := New(csrf)
+	e.PushHandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(CSRFField(r)))
+	})
+	serve := func(method string, path string, body string, setup func(*http.Request)) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, "http://localhost:8384"+path, strings.NewReader(body))
+		if err != nil {
+			t.Error(err)
+		}
+		if body != "" {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		setup(req)
+		recorder := httptest.NewRecorder()
+		e.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	form := serve("GET", "/form", "", func(req *http.Request) {})
+	expect(t, form.Code, http.StatusOK)
+	cookies := form.Result().Cookies()
+	expect(t, len(cookies), 1)
+	expect(t, cookies[0].HttpOnly, true)
+	token := regexp.MustCompile(`name="csrf_token" value="([^"]+)"`).FindStringSubmatch(form.Body.String())[1]
+	withCookie := func(req *http.Request) {
+		req.AddCookie(cookies[0])
+	}
+
+	expect(t, serve("POST", "/form", "", withCookie).Code, http.StatusForbidden)
+	expect(t, serve("POST", "/form", "csrf_token="+url.QueryEscape(token), withCookie).Code, http.StatusOK)
+	expect(t, serve("POST", "/form", "", func(req *http.Request) {
+		withCookie(req)
+		req.Header.Set("X-CSRF-Token", token)
+		req.Header.Set("Origin", "https://admin.example.com")
+	}).Code, http.StatusOK)
+	expect(t, serve("POST", "/form", "", func(req *http.Request) {
+		withCookie(req)
+		req.Header.Set("X-CSRF-Token", token)
+		req.Header.Set("Origin", "https://evil.example.com")
+	}).Code, http.StatusForbidden)
+	expect(t, serve("POST", "/form", "", func(req *http.Request) {
+		withCookie(req)
+		req.Header.Set("X-CSRF-Token", token)
+		req.Header.Set("Referer", "http://localhost:8384/form")
+	}).Code, http.StatusOK)
+	// A token for another secret is rejected.
+	expect(t, serve("POST", "/form", "", func(req *http.Request) {
+		req.AddCookie(&http.Cookie{Name: "csrf_token", Value: strings.Repeat("A", 43)})
+		req.Header.Set("X-CSRF-Token", token)
+	}).Code, http.StatusForbidden)
+	// A cookie and token pair planted by someone without the key is rejected.
+	secret := []byte(strings.Repeat("s", csrfTokenLength))
+	planted := (&CSRF{Key: []byte("another key"), CookieName: "csrf_token"}).sign(secret)
+	expect(t, serve("POST", "/form", "", func(req *http.Request) {
+		req.AddCookie(&http.Cookie{Name: "csrf_token", Value: base64.RawURLEncoding.EncodeToString(append(secret, planted...))})
+		req.Header.Set("X-CSRF-Token", base64.RawURLEncoding.EncodeToString(append(make([]byte, csrfTokenLength), secret...)))
+	}).Code, http.StatusForbidden)
+
+	expect(t, serve("POST", "/webhooks/github", "", func(req *http.Request) {}).Code, http.StatusOK)
+	// Bearer token requests are only exempt when asked for.
+	bearer := func(req *http.Request) {
+		req.Header.Set("Authorization", "Bearer token")
+	}
+	expect(t, serve("POST", "/api", "", bearer).Code, http.StatusForbidden)
+	csrf.ExemptBearer = true
+	expect(t, serve("POST", "/api", "", bearer).Code, http.StatusOK)
+
+	expect(t, len(failures), 5)
+	expect(t, errors.Is(failures[0], ErrCSRFToken), true)
+	expect(t, errors.Is(failures[1], ErrCSRFOrigin), true)
+}
+
+func Test_CSRFSession(t *testing.T) {
+	var token string
+	e := New(NewSessions(testSessionKey), NewCSRF(testCSRFKey))
+	e.PushHandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token = CSRFToken(r)
+	})
+	client := &sessionClient{t: t, e: e}
+	client.serve("/form")
+	// The token is kept in the session rather than its own cookie.
+	expect(t, client.cookie.Name, "session")
+	first := token
+	client.serve("/form")
+	// Tokens are masked differently every time.
+	refute(t, token, first)
+
+	req, err := http.NewRequest("POST", "http://localhost:8384/form", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	req.AddCookie(client.cookie)
+	req.Header.Set("X-CSRF-Token", first)
+	recorder := httptest.NewRecorder()
+	e.ServeHTTP(recorder, req)
+	expect(t, recorder.Code, http.StatusOK)
+}
+
+func Test_CSRFOriginScheme(t *testing.T) {
+	csrf := NewCSRF(testCSRFKey)
+	for _, test := range []struct {
+		url       string
+		forwarded string
+		proxies   []netip.Prefix
+		origin    string
+		allowed   bool
+	}{
+		{"http://localhost:8384/form", "", nil, "http://localhost:8384", true},
+		{"http://localhost:8384/form", "", nil, "https://localhost:8384", false},
+		{"https://localhost:8384/form", "", nil, "https://localhost:8384", true},
+		// An insecure page can't post to the secure origin on the same host.
+		{"https://localhost:8384/form", "", nil, "http://localhost:8384", false},
+		// The scheme is taken from a trusted proxy terminating TLS.
+		{"http://localhost:8384/form", "https", []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")}, "https://localhost:8384", true},
+		{"http://localhost:8384/form", "http, https", []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")}, "http://localhost:8384", false},
+		// The header is ignored from anyone else.
+		{"http://localhost:8384/form", "https", []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}, "https://localhost:8384", false},
+	} {
+		req := httptest.NewRequest("POST", test.url, nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		if test.forwarded != "" {
+			req.Header.Set("X-Forwarded-Proto", test.forwarded)
+		}
+		csrf.TrustedProxies = test.proxies
+		expect(t, csrf.allowedOrigin(req, test.origin), test.allowed)
+	}
+}
+
+func Test_NewCSRFKeyLength(t *testing.T) {
+	defer func() {
+		refute(t, recover(), nil)
+	}()
+	NewCSRF([]byte("too short"))
+}
@@ -0,0 +1,328 @@
+package entre
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"hash"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// digestStale is the reason given when a request's nonce has expired but its credentials are correct,
+// in which case clients are told to retry with a new nonce rather than asking the user again.
+const digestStale = "stale nonce"
+
+// DigestAuth provides the HTTP digest authentication middleware as described in RFC 7616,
+// with the MD5 and SHA-256 algorithms and the "auth" quality of protection.
+// Nonces are generated and signed by the middleware and expire after NonceTTL,
+// each nonce count can only be used once per nonce so captured requests can't be replayed.
+// Counts can arrive out of order, as long as they are within 64 of the highest count used.
+type DigestAuth struct {
+	// Realm is the protection space sent to clients in the WWW-Authenticate header,
+	// it is part of what clients hash so changing it invalidates stored hashes.
+	Realm string
+	// Store provides the HA1 hashes of users, the Users and Htdigest stores can be shared with BasicAuth.
+	Store DigestStore
+	// Algorithms are the algorithms offered to clients in order of preference.
+	Algorithms []string
+	// NonceTTL is how long nonces can be used for.
+	NonceTTL time.Duration
+	// Lockout throttles repeated failures per client IP and per username, when set.
+	// Locked out clients are responded to with a 429 Too Many Requests.
+	Lockout *Lockout
+	// OnFailure is called for each failed attempt, when set.
+	OnFailure func(r *http.Request, failure *AuthFailure)
+
+	keyOnce sync.Once
+	key     []byte
+	mu      sync.Mutex
+	counts  map[string]*digestCount
+	pruned  time.Time
+}
+
+// digestNonceWindow is how far below the highest nonce count used with a nonce other counts can be
+// and still be accepted, so concurrent requests arriving out of order aren't taken as replays.
+const digestNonceWindow = 64
+
+// digestCount records the nonce counts used with a nonce, the highest one along with
+// a bitmap of the counts just below it where bit i is set when highest-i has been used.
+type digestCount struct {
+	highest uint64
+	seen    uint64
+	expires time.Time
+}
+
+// NewDigestAuth creates a new digest auth instance which checks credentials against
+// the provided store, offering SHA-256 and then MD5 with nonces lasting 5 minutes.
+func NewDigestAuth(store DigestStore) *DigestAuth {
+	return &DigestAuth{
+		Realm:      "Restricted",
+		Store:      store,
+		Algorithms: []string{"SHA-256", "MD5"},
+		NonceTTL:   5 * time.Minute,
+	}
+}
+
+func (d *DigestAuth) ServeHTTP(w http.ResponseWriter, r *http.Request, ps httprouter.Params, next http.HandlerFunc) {
+	params, ok := parseDigestParams(r.Header.Get("Authorization"))
+	if !ok {
+		d.challenge(w, false)
+		return
+	}
+	usr := params["username"]
+	keys := []string{"ip:" + ClientIPFrom(r), "user:" + usr}
+	if d.Lockout != nil {
+		if remaining := d.Lockout.Locked(keys...); remaining > 0 {
+			d.fail(r, usr, "locked out", remaining)
+			tooManyRequests(w, remaining)
+			return
+		}
+	}
+	reason := d.verify(r, params)
+	if reason == "" {
+		if d.Lockout != nil {
+			d.Lockout.Reset(keys[1])
+		}
+		next(w, WithPrincipal(r, &Principal{ID: usr, Name: usr, AuthMethod: "digest"}))
+		return
+	}
+	// A stale nonce with the right credentials isn't counted as a failure.
+	if reason == digestStale {
+		d.challenge(w, true)
+		return
+	}
+	var remaining time.Duration
+	if d.Lockout != nil {
+		remaining = d.Lockout.Fail(keys...)
+	}
+	d.fail(r, usr, reason, remaining)
+	d.challenge(w, false)
+}
+
+// verify checks the digest credentials of a request, providing the reason they were rejected
+// or an empty string when they are valid.
+func (d *DigestAuth) verify(r *http.Request, params map[string]string) string {
+	algorithm := params["algorithm"]
+	if algorithm == "" {
+		algorithm = "MD5"
+	}
+	h := d.hash(algorithm)
+	if h == nil {
+		return "unsupported algorithm"
+	}
+	if params["userhash"] == "true" {
+		return "unsupported userhash"
+	}
+	if params["realm"] != d.Realm {
+		return "wrong realm"
+	}
+	if params["qop"] != "auth" {
+		return "unsupported qop"
+	}
+	uri := r.RequestURI
+	if uri == "" {
+		uri = r.URL.RequestURI()
+	}
+	if params["uri"] != uri {
+		return "wrong uri"
+	}
+	nc, err := strconv.ParseUint(params["nc"], 16, 64)
+	if err != nil || nc == 0 || len(params["nc"]) != 8 || params["cnonce"] == "" {
+		return "malformed credentials"
+	}
+	expires, validNonce := d.checkNonce(params["nonce"])
+
+	var ha1 string
+	var known bool
+	if d.Store != nil {
+		ha1, known = d.Store.HA1(params["username"], d.Realm, algorithm)
+	}
+	// The response is computed for unknown users too so their timing doesn't differ.
+	if !known {
+		ha1 = digestHex(h, params["username"]+":"+d.Realm+":")
+	}
+	ha2 := digestHex(h, r.Method+":"+params["uri"])
+	expected := digestHex(h, ha1+":"+params["nonce"]+":"+params["nc"]+":"+params["cnonce"]+":auth:"+ha2)
+	matches := subtle.ConstantTimeCompare([]byte(expected), []byte(strings.ToLower(params["response"]))) == 1
+	switch {
+	case !validNonce:
+		return "invalid nonce"
+	case !matches || !known:
+		return "invalid credentials"
+	case time.Now().After(expires):
+		return digestStale
+	case !d.useNonce(params["nonce"], nc, expires):
+		return "replayed nonce count"
+	}
+	return ""
+}
+
+// hash provides the hash function for an algorithm offered to clients, nil for any other algorithm.
+func (d *DigestAuth) hash(algorithm string) func() hash.Hash {
+	if !containsString(d.Algorithms, algorithm) {
+		return nil
+	}
+	return digestHash(algorithm)
+}
+
+// digestHash provides the hash function for a digest algorithm, nil when it isn't supported.
+func digestHash(algorithm string) func() hash.Hash {
+	switch algorithm {
+	case "MD5":
+		return md5.New
+	case "SHA-256":
+		return sha256.New
+	}
+	return nil
+}
+
+// newNonce generates a nonce holding its expiry, signed so it can be checked without keeping it around.
+func (d *DigestAuth) newNonce() string {
+	nonce := make([]byte, 24, 40)
+	binary.BigEndian.PutUint64(nonce, uint64(time.Now().Add(d.NonceTTL).Unix()))
+	rand.Read(nonce[8:])
+	return base64.RawURLEncoding.EncodeToString(append(nonce, d.sign(nonce)...))
+}
+
+// checkNonce checks a nonce was generated by the middleware, providing when it expires.
+func (d *DigestAuth) checkNonce(nonce string) (time.Time, bool) {
+	decoded, err := base64.RawURLEncoding.DecodeString(nonce)
+	if err != nil || len(decoded) != 40 || !hmac.Equal(decoded[24:], d.sign(decoded[:24])) {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(binary.BigEndian.Uint64(decoded)), 0), true
+}
+
+func (d *DigestAuth) sign(data []byte) []byte {
+	d.keyOnce.Do(func() {
+		d.key = make([]byte, 32)
+		rand.Read(d.key)
+	})
+	mac := hmac.New(sha256.New, d.key)
+	mac.Write(data)
+	return mac.Sum(nil)[:16]
+}
+
+// useNonce records the use of a nonce count, which must not have been used before with the nonce
+// and must be within digestNonceWindow of the highest count used with it.
+func (d *DigestAuth) useNonce(nonce string, nc uint64, expires time.Time) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.counts == nil {
+		d.counts = map[string]*digestCount{}
+	}
+	now := time.Now()
+	// Expired nonces are cleared out every so often.
+	if now.Sub(d.pruned) > time.Minute {
+		for n, count := range d.counts {
+			if now.After(count.expires) {
+				delete(d.counts, n)
+			}
+		}
+		d.pruned = now
+	}
+	count, ok := d.counts[nonce]
+	if !ok {
+		count = &digestCount{expires: expires}
+		d.counts[nonce] = count
+	}
+	switch {
+	case nc > count.highest:
+		if shift := nc - count.highest; shift < digestNonceWindow {
+			count.seen = count.seen<<shift | 1
+		} else {
+			count.seen = 1
+		}
+		count.highest = nc
+	case count.highest-nc >= digestNonceWindow:
+		return false
+	default:
+		bit := uint64(1) << (count.highest - nc)
+		if count.seen&bit != 0 {
+			return false
+		}
+		count.seen |= bit
+	}
+	return true
+}
+
+// challenge responds with a 401 offering a new nonce for each of the Algorithms.
+func (d *DigestAuth) challenge(w http.ResponseWriter, stale bool) {
+	nonce := d.newNonce()
+	for _, algorithm := range d.Algorithms {
+		challenge := "Digest realm=" + strconv.Quote(d.Realm) + `, qop="auth", algorithm=` + algorithm +
+			`, nonce="` + nonce + `", charset=UTF-8, userhash=false`
+		if stale {
+			challenge += ", stale=true"
+		}
+		w.Header().Add("WWW-Authenticate", challenge)
+	}
+	http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+}
+
+func (d *DigestAuth) fail(r *http.Request, usr string, reason string, lockedFor time.Duration) {
+	if d.OnFailure == nil {
+		return
+	}
+	d.OnFailure(r, newAuthFailure(r, usr, reason, lockedFor))
+}
+
+func digestHex(h func() hash.Hash, s string) string {
+	hasher := h()
+	hasher.Write([]byte(s))
+	return hex.EncodeToString(hasher.Sum(nil))
+}
+
+// parseDigestParams parses the parameters of a digest Authorization header.
+func parseDigestParams(header string) (map[string]string, bool) {
+	scheme, rest, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Digest") {
+		return nil, false
+	}
+	params := map[string]string{}
+	for {
+		rest = strings.TrimLeft(rest, " \t,")
+		if rest == "" {
+			return params, true
+		}
+		name, value, ok := strings.Cut(rest, "=")
+		if !ok {
+			return nil, false
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		value = strings.TrimLeft(value, " \t")
+		if strings.HasPrefix(value, `"`) {
+			var b strings.Builder
+			i := 1
+			for ; i < len(value) && value[i] != '"'; i++ {
+				if value[i] == '\\' && i+1 < len(value) {
+					i++
+				}
+				b.WriteByte(value[i])
+			}
+			if i == len(value) {
+				return nil, false
+			}
+			params[name] = b.String()
+			rest = value[i+1:]
+		} else {
+			end := strings.IndexAny(value, ", \t")
+			if end < 0 {
+				end = len(value)
+			}
+			params[name] = value[:end]
+			rest = value[end:]
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package entre
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// digestAuthorization answers a digest challenge the way a client would.
+func digestAuthorization(challenge string, method string, uri string, user string, password string, nc int) string {
+	params, _ := parseDigestParams(challenge)
+	h := md5.New
+	if params["algorithm"] == "SHA-256" {
+		h = sha256.New
+	}
+	ncValue := fmt.Sprintf("%08x", nc)
+	cnonce := "0a4f113b"
+	ha1 := digestHex(h, user+":"+params["realm"]+":"+password)
+	ha2 := digestHex(h, method+":"+uri)
+	response := digestHex(h, ha1+":"+params["nonce"]+":"+ncValue+":"+cnonce+":auth:"+ha2)
+	return fmt.Sprintf(`Digest username="%s", realm="%s", uri="%s", algorithm=%s, nonce="%s", nc=%s, cnonce="%s", qop=auth, response="%s"`,
+		user, params["realm"], uri, params["algorithm"], params["nonce"], ncValue, cnonce, response)
+}
+
+func serveDigest(e *Entre, uri string, authorization string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", uri, nil)
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	recorder := httptest.NewRecorder()
+	e.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func Test_DigestResponse(t *testing.T) {
+	// The example from RFC 7616 section 3.9.1.
+	for _, test := range []struct {
+		h        func() hash.Hash
+		response string
+	}{
+		{md5.New, "8ca523f5e9506fed4657c9700eebdbec"},
+		{sha256.New, "753927fa0e85d155564e2e272a28d1802ca10daf4496794697cf8db5856cb6c1"},
+	} {
+		ha1 := digestHex(test.h, "Mufasa:http-auth@example.org:Circle of Life")
+		ha2 := digestHex(test.h, "GET:/dir/index.html")
+		expect(t, digestHex(test.h, ha1+":7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v:00000001:"+
+			"f2/wE4q74E6zIJEtWaHKaf5wv/H5QzzpXusqGemxURZJ:auth:"+ha2), test.response)
+	}
+}
+
+func Test_DigestAuth(t *testing.T) {
+	users := Users{"Mufasa": "Circle of Life"}
+	digest := NewDigestAuth(users)
+	digest.Realm = "http-auth@example.org"
+	e := New(digest)
+	e.PushHandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(PrincipalFrom(r).Name + " " + PrincipalFrom(r).AuthMethod))
+	})
+
+	recorder := serveDigest(e, "/dir/index.html", "")
+	expect(t, recorder.Code, http.StatusUnauthorized)
+	challenges := recorder.Header().Values("WWW-Authenticate")
+	expect(t, len(challenges), 2)
+	expect(t, strings.HasPrefix(challenges[0], `Digest realm="http-auth@example.org", qop="auth", algorithm=SHA-256, nonce="`), true)
+	expect(t, strings.Contains(challenges[1], "algorithm=MD5"), true)
+
+	// Both challenges share the nonce so each answer needs its own nonce count.
+	for i, challenge := range challenges {
+		recorder = serveDigest(e, "/dir/index.html", digestAuthorization(challenge, "GET", "/dir/index.html", "Mufasa", "Circle of Life", i+1))
+		expect(t, recorder.Code, http.StatusOK)
+		expect(t, recorder.Body.String(), "Mufasa digest")
+	}
+
+	// The same store works for basic auth.
+	basic := New(NewBasicAuthWithStore(users))
+	req := httptest.NewRequest("GET", "/", nil)
+	req.SetBasicAuth("Mufasa", "Circle of Life")
+	recorder = httptest.NewRecorder()
+	basic.ServeHTTP(recorder, req)
+	expect(t, recorder.Code, http.StatusOK)
+}
+
+func Test_DigestAuthRejected(t *testing.T) {
+	var reasons []string
+	digest := NewDigestAuth(Users{"user": "password"})
+	digest.OnFailure = func(r *http.Request, failure *AuthFailure) {
+		reasons = append(reasons, failure.Reason)
+	}
+	e := New(digest)
+	challenge := serveDigest(e, "/", "").Header().Get("WWW-Authenticate")
+
+	expect(t, serveDigest(e, "/", digestAuthorization(challenge, "GET", "/", "user", "wrong", 1)).Code, http.StatusUnauthorized)
+	expect(t, serveDigest(e, "/", digestAuthorization(challenge, "GET", "/", "nobody", "password", 1)).Code, http.StatusUnauthorized)
+	// Credentials for one URI can't be used for another.
+	expect(t, serveDigest(e, "/admin", digestAuthorization(challenge, "GET", "/", "user", "password", 1)).Code, http.StatusUnauthorized)
+	// Nonces must have been generated by the middleware.
+	forged := strings.Replace(challenge, `nonce="`, `nonce="A`, 1)
+	expect(t, serveDigest(e, "/", digestAuthorization(forged, "GET", "/", "user", "password", 1)).Code, http.StatusUnauthorized)
+	// Algorithms which weren't offered are refused.
+	digest.Algorithms = []string{"SHA-256"}
+	md5Challenge := strings.Replace(challenge, "algorithm=SHA-256", "algorithm=MD5", 1)
+	expect(t, serveDigest(e, "/", digestAuthorization(md5Challenge, "GET", "/", "user", "password", 1)).Code, http.StatusUnauthorized)
+	expect(t, strings.Join(reasons, ", "), "invalid credentials, invalid credentials, wrong uri, invalid nonce, unsupported algorithm")
+}
+
+func Test_DigestAuthReplay(t *testing.T) {
+	digest := NewDigestAuth(Users{"user": "password"})
+	e := New(digest)
+	challenge := serveDigest(e, "/", "").Header().Get("WWW-Authenticate")
+
+	first := digestAuthorization(challenge, "GET", "/", "user", "password", 1)
+	expect(t, serveDigest(e, "/", first).Code, http.StatusOK)
+	// Replaying a request is refused, while later nonce counts are accepted.
+	expect(t, serveDigest(e, "/", first).Code, http.StatusUnauthorized)
+	expect(t, serveDigest(e, "/", digestAuthorization(challenge, "GET", "/", "user", "password", 2)).Code, http.StatusOK)
+	expect(t, serveDigest(e, "/", digestAuthorization(challenge, "GET", "/", "user", "password", 2)).Code, http.StatusUnauthorized)
+
+	// Concurrent requests can arrive out of order, each count still being usable only once.
+	expect(t, serveDigest(e, "/", digestAuthorization(challenge, "GET", "/", "user", "password", 5)).Code, http.StatusOK)
+	expect(t, serveDigest(e, "/", digestAuthorization(challenge, "GET", "/", "user", "password", 4)).Code, http.StatusOK)
+	expect(t, serveDigest(e, "/", digestAuthorization(challenge, "GET", "/", "user", "password", 3)).Code, http.StatusOK)
+	expect(t, serveDigest(e, "/", digestAuthorization(challenge, "GET", "/", "user", "password", 4)).Code, http.StatusUnauthorized)
+	// Counts too far behind the highest can't be told apart from replays.
+	expect(t, serveDigest(e, "/", digestAuthorization(challenge, "GET", "/", "user", "password", 100)).Code, http.StatusOK)
+	expect(t, serveDigest(e, "/", digestAuthorization(challenge, "GET", "/", "user", "password", 6)).Code, http.StatusUnauthorized)
+	expect(t, serveDigest(e, "/", digestAuthorization(challenge, "GET", "/", "user", "password", 37)).Code, http.StatusOK)
+	expect(t, serveDigest(e, "/", digestAuthorization(challenge, "GET", "/", "user", "password", 0)).Code, http.StatusUnauthorized)
+}
+
+func Test_DigestAuthStale(t *testing.T) {
+	var failures int
+	digest := NewDigestAuth(Users{"user": "password"})
+	digest.NonceTTL = -time.Second
+	digest.OnFailure = func(r *http.Request, failure *AuthFailure) {
+		failures++
+	}
+	e := New(digest)
+	challenge := serveDigest(e, "/", "").Header().Get("WWW-Authenticate")
+
+	// Expired nonces with the right credentials ask the client to retry with a new nonce.
+	recorder := serveDigest(e, "/", digestAuthorization(challenge, "GET", "/", "user", "password", 1))
+	expect(t, recorder.Code, http.StatusUnauthorized)
+	expect(t, strings.HasSuffix(recorder.Header().Get("WWW-Authenticate"), ", stale=true"), true)
+	expect(t, failures, 0)
+
+	recorder = serveDigest(e, "/", digestAuthorization(challenge, "GET", "/", "user", "wrong", 1))
+	expect(t, strings.Contains(recorder.Header().Get("WWW-Authenticate"), "stale"), false)
+	expect(t, failures, 1)
+}
+
+func Test_DigestAuthLockout(t *testing.T) {
+	digest := NewDigestAuth(Users{"user": "password"})
+	digest.Lockout = NewLockout(1)
+	digest.Lockout.BaseDelay = time.Minute
+	e := New(digest)
+	challenge := serveDigest(e, "/", "").Header().Get("WWW-Authenticate")
+
+	expect(t, serveDigest(e, "/", digestAuthorization(challenge, "GET", "/", "user", "wrong", 1)).Code, http.StatusUnauthorized)
+	recorder := serveDigest(e, "/", digestAuthorization(challenge, "GET", "/", "user", "password", 2))
+	expect(t, recorder.Code, http.StatusTooManyRequests)
+	expect(t, recorder.Header().Get("Retry-After"), "60")
+}
+
+func Test_ParseDigestParams(t *testing.T) {
+	params, ok := parseDigestParams(`Digest username="Mu\"fasa", nc=00000001,qop=auth, uri="/a,b"`)
+	expect(t, ok, true)
+	expect(t, params["username"], `Mu"fasa`)
+	expect(t, params["nc"], "00000001")
+	expect(t, params["qop"], "auth")
+	expect(t, params["uri"], "/a,b")
+
+	_, ok = parseDigestParams(`Basic dXNlcjpwYXNz`)
+	expect(t, ok, false)
+	_, ok = parseDigestParams(`Digest username="unterminated`)
+	expect(t, ok, false)
+}
@@ -0,0 +1,41 @@
+package entre
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+// fileWatch keeps track of the size and modification time of a file as it was last loaded,
+// so stores backed by a file only reload it once it has changed.
+type fileWatch struct {
+	mu        sync.Mutex
+	modTime   time.Time
+	size      int64
+	lastCheck time.Time
+}
+
+// loaded records the state of the file which has just been loaded.
+func (fw *fileWatch) loaded(info os.FileInfo) {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	fw.modTime = info.ModTime()
+	fw.size = info.Size()
+	fw.lastCheck = time.Now()
+}
+
+// changed reports whether the size or modification time of the file has changed since it was
+// last loaded, checking at most once per interval. When the file can't be reloaded after it has
+// changed the store keeps what it loaded last, the file being checked again after the interval.
+func (fw *fileWatch) changed(filename string, interval time.Duration) bool {
+	fw.mu.Lock()
+	if time.Since(fw.lastCheck) < interval {
+		fw.mu.Unlock()
+		return false
+	}
+	fw.lastCheck = time.Now()
+	modTime, size := fw.modTime, fw.size
+	fw.mu.Unlock()
+	info, err := os.Stat(filename)
+	return err == nil && !(info.ModTime().Equal(modTime) && info.Size() == size)
+}
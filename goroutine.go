@@ -0,0 +1,93 @@
+package entre
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+// background tracks the goroutines started with Go.
+var background = &goroutineTracker{}
+
+// Go runs fn in a new goroutine with the same panic recovery as the request it was started from.
+// A panic in fn is logged, reported and passed to the ErrorHandlerFunc of the PanicRecovery
+// middleware serving the request, along with the request ID and route, instead of crashing the server.
+// When the request isn't being served by a PanicRecovery middleware, or r is nil, a default one is used.
+// The goroutine is tracked so a graceful shutdown can wait for it with Wait.
+func Go(r *http.Request, fn func()) {
+	ctx := context.Background()
+	if r != nil {
+		ctx = r.Context()
+	}
+	pr, _ := ctx.Value(recoveryKey{}).(*PanicRecovery)
+	if pr == nil {
+		pr = NewPanicRecovery(false)
+	}
+	if r != nil {
+		// The request context will be cancelled once the response has been written
+		// so only its values are carried over to the background work.
+		r = r.WithContext(context.WithoutCancel(ctx))
+	}
+	background.add()
+	go func() {
+		defer background.done()
+		defer func() {
+			if err := recover(); err != nil {
+				pr.notify(r, pr.capture(r, stormRouteKey(r), err, "PANIC in background goroutine", ""))
+			}
+		}()
+		fn()
+	}()
+}
+
+// Wait blocks until every goroutine started with Go has finished or the context is done,
+// in which case the context's error is returned. This is intended to be called
+// during graceful shutdown once the server has stopped accepting requests.
+//
+//	server.Shutdown(ctx)
+//	entre.Wait(ctx)
+func Wait(ctx context.Context) error {
+	return background.wait(ctx)
+}
+
+// goroutineTracker counts running goroutines, unlike sync.WaitGroup it allows
+// goroutines to be started while something is waiting.
+type goroutineTracker struct {
+	mu      sync.Mutex
+	running int
+	idle    chan struct{}
+}
+
+func (t *goroutineTracker) add() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.running == 0 {
+		t.idle = make(chan struct{})
+	}
+	t.running++
+}
+
+func (t *goroutineTracker) done() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.running--
+	if t.running == 0 {
+		close(t.idle)
+	}
+}
+
+func (t *goroutineTracker) wait(ctx context.Context) error {
+	t.mu.Lock()
+	if t.running == 0 {
+		t.mu.Unlock()
+		return nil
+	}
+	idle := t.idle
+	t.mu.Unlock()
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,101 @@
+package entre
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func Test_Go(t *testing.T) {
+	buf := &syncBuffer{}
+	reporter := NewMemoryReporter()
+	r := NewPanicRecovery(false)
+	r.Logger = log.New(buf, "|-entre-|", 0)
+	r.Reporter = reporter
+	e := New(NewRequestID(), UseRoute("/test"), r)
+	e.PushHandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		Go(req, func() {
+			panic("You have caused a panic in the background")
+		})
+		res.WriteHeader(http.StatusAccepted)
+	})
+	req, err := http.NewRequest("GET", "http://localhost:8384/test", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	req.Header.Set("X-Request-ID", "my-request-id")
+	recorder := httptest.NewRecorder()
+	e.ServeHTTP(recorder, req)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := Wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, recorder.Code, http.StatusAccepted)
+	expect(t, strings.Contains(buf.String(), "[my-request-id] PANIC in background goroutine: You have caused a panic in the background"), true)
+	reports := reporter.Reports()
+	expect(t, len(reports), 1)
+	expect(t, reports[0].RequestID, "my-request-id")
+	expect(t, reports[0].Route, "/test")
+	expect(t, strings.HasSuffix(reports[0].Frames[0].Function, "Test_Go.func1.1"), true)
+}
+
+func Test_GoWithoutRequest(t *testing.T) {
+	ran := make(chan struct{})
+	Go(nil, func() {
+		close(ran)
+		panic("You have caused a panic in the background")
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := Wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+	<-ran
+}
+
+func Test_GoWait(t *testing.T) {
+	req, err := http.NewRequest("GET", "http://localhost:8384/test", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	started := make(chan struct{})
+	finish := make(chan struct{})
+	Go(req, func() {
+		close(started)
+		<-finish
+	})
+	<-started
+
+	// Wait should time out while the goroutine is still running.
+	waitCtx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	expect(t, Wait(waitCtx), context.DeadlineExceeded)
+	close(finish)
+	if err := Wait(context.Background()); err != nil {
+		t.Error(err)
+	}
+}
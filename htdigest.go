@@ -0,0 +1,106 @@
+package entre
+
+import (
+	"bufio"
+	"crypto/md5"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Htdigest is a credential store backed by an Apache htdigest file, whose entries are
+// "user:realm:HA1" lines with HA1 the hex encoded MD5 of "user:realm:password".
+// It can be used with DigestAuth, offering only the MD5 algorithm as the file has no other hashes,
+// as well as with BasicAuth, which checks passwords in Realm only. The file is reloaded when it changes.
+type Htdigest struct {
+	// Filename is the path of the htdigest file.
+	Filename string
+	// Realm is the realm passwords are checked in by Authenticate, entries for other realms are ignored.
+	Realm string
+	// CheckInterval is how often the file is checked for changes.
+	CheckInterval time.Duration
+
+	mu    sync.RWMutex
+	users map[string]map[string]string
+	watch fileWatch
+}
+
+// NewHtdigest loads the provided htdigest file, checking passwords in the provided realm,
+// which is checked for changes at most every 5 seconds.
+func NewHtdigest(filename string, realm string) (*Htdigest, error) {
+	h := &Htdigest{
+		Filename:      filename,
+		Realm:         realm,
+		CheckInterval: 5 * time.Second,
+	}
+	if err := h.Reload(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// Reload re-reads the htdigest file.
+func (h *Htdigest) Reload() error {
+	f, err := os.Open(h.Filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	users := map[string]map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ":")
+		if len(fields) != 3 || fields[0] == "" || len(fields[2]) != 2*md5.Size {
+			return fmt.Errorf("%s:%d: malformed htdigest entry", h.Filename, lineNo)
+		}
+		if users[fields[0]] == nil {
+			users[fields[0]] = map[string]string{}
+		}
+		users[fields[0]][fields[1]] = strings.ToLower(fields[2])
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.users = users
+	h.watch.loaded(info)
+	return nil
+}
+
+// HA1 provides the HA1 hash of the provided user in the provided realm, for the MD5 algorithm only.
+func (h *Htdigest) HA1(user string, realm string, algorithm string) (string, bool) {
+	if algorithm != "MD5" {
+		return "", false
+	}
+	realms := h.realms(user)
+	ha1, ok := realms[realm]
+	return ha1, ok
+}
+
+// Authenticate checks the password of the provided user against their entry for Realm in the file.
+func (h *Htdigest) Authenticate(user string, password string) bool {
+	ha1, ok := h.realms(user)[h.Realm]
+	// The hash is computed for unknown users too so their timing doesn't differ.
+	return secureCompare(digestHex(md5.New, user+":"+h.Realm+":"+password), ha1) && ok
+}
+
+// realms provides the HA1 hashes of the provided user by realm, reloading the file when it has changed.
+func (h *Htdigest) realms(user string) map[string]string {
+	if h.watch.changed(h.Filename, h.CheckInterval) {
+		h.Reload()
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.users[user]
+}
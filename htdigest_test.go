@@ -0,0 +1,79 @@
+package entre
+
+import (
+	"crypto/md5"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeHtdigest(t *testing.T, content string) string {
+	name := filepath.Join(t.TempDir(), ".htdigest")
+	if err := os.WriteFile(name, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func Test_Htdigest(t *testing.T) {
+	h, err := NewHtdigest(writeHtdigest(t, "# Devices\n"+
+		"Mufasa:http-auth@example.org:"+digestHex(md5.New, "Mufasa:http-auth@example.org:Circle of Life")+"\n"+
+		"Mufasa:other@example.org:"+strings.ToUpper(digestHex(md5.New, "Mufasa:other@example.org:Hakuna Matata"))+"\n"), "http-auth@example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ha1, ok := h.HA1("Mufasa", "http-auth@example.org", "MD5")
+	expect(t, ok, true)
+	expect(t, ha1, digestHex(md5.New, "Mufasa:http-auth@example.org:Circle of Life"))
+	// The file only has MD5 hashes.
+	_, ok = h.HA1("Mufasa", "http-auth@example.org", "SHA-256")
+	expect(t, ok, false)
+	_, ok = h.HA1("Mufasa", "unknown@example.org", "MD5")
+	expect(t, ok, false)
+
+	// Passwords are only checked in the store's realm, not whichever realm the user is in.
+	expect(t, h.Authenticate("Mufasa", "Circle of Life"), true)
+	expect(t, h.Authenticate("Mufasa", "Hakuna Matata"), false)
+	expect(t, h.Authenticate("Mufasa", "wrong"), false)
+	expect(t, h.Authenticate("Scar", "Circle of Life"), false)
+	h.Realm = "other@example.org"
+	expect(t, h.Authenticate("Mufasa", "Hakuna Matata"), true)
+	expect(t, h.Authenticate("Mufasa", "Circle of Life"), false)
+
+	_, err = NewHtdigest(writeHtdigest(t, "Mufasa:Circle of Life\n"), "http-auth@example.org")
+	refute(t, err, nil)
+}
+
+func Test_DigestAuthHtdigest(t *testing.T) {
+	name := writeHtdigest(t, "user:devices:"+digestHex(md5.New, "user:devices:password")+"\n")
+	h, err := NewHtdigest(name, "devices")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.CheckInterval = 0
+	digest := NewDigestAuth(h)
+	digest.Realm = "devices"
+	digest.Algorithms = []string{"MD5"}
+	e := New(digest)
+	challenge := serveDigest(e, "/", "").Header().Get("WWW-Authenticate")
+	expect(t, serveDigest(e, "/", digestAuthorization(challenge, "GET", "/", "user", "password", 1)).Code, http.StatusOK)
+	expect(t, serveDigest(e, "/", digestAuthorization(challenge, "GET", "/", "user", "wrong", 2)).Code, http.StatusUnauthorized)
+
+	// The same store works for basic auth.
+	basic := New(NewBasicAuthWithStore(h))
+	req := httptest.NewRequest("GET", "/", nil)
+	req.SetBasicAuth("user", "password")
+	recorder := httptest.NewRecorder()
+	basic.ServeHTTP(recorder, req)
+	expect(t, recorder.Code, http.StatusOK)
+
+	// Changes to the file are picked up.
+	os.WriteFile(name, []byte("user:devices:"+digestHex(md5.New, "user:devices:changed")+"\n"), 0600)
+	os.Chtimes(name, time.Now().Add(time.Minute), time.Now().Add(time.Minute))
+	expect(t, serveDigest(e, "/", digestAuthorization(challenge, "GET", "/", "user", "password", 3)).Code, http.StatusUnauthorized)
+	expect(t, serveDigest(e, "/", digestAuthorization(challenge, "GET", "/", "user", "changed", 4)).Code, http.StatusOK)
+}
@@ -0,0 +1,216 @@
+package entre
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Htpasswd is a credential store backed by an Apache htpasswd file.
+// It supports {SHA}, {SSHA}, apr1 and MD5 crypt hashes along with plaintext passwords,
+// bcrypt hashes are supported through a pluggable verifier. Entries with any other "$" or "{"
+// prefixed hash are rejected, while entries without a prefix are taken as plaintext,
+// so DES crypt hashes aren't supported. The file is reloaded when it changes.
+type Htpasswd struct {
+	// Filename is the path of the htpasswd file.
+	Filename string
+	// Bcrypt verifies a password against a bcrypt hash, bcrypt entries are rejected when nil.
+	// golang.org/x/crypto/bcrypt can be plugged in like so:
+	//
+	//	h.Bcrypt = func(hash, password string) bool {
+	//		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	//	}
+	Bcrypt func(hash string, password string) bool
+	// CheckInterval is how often the file is checked for changes.
+	CheckInterval time.Duration
+
+	mu    sync.RWMutex
+	users map[string]string
+	dummy string
+	watch fileWatch
+}
+
+// NewHtpasswd loads the provided htpasswd file, which is checked
+// for changes at most every 5 seconds.
+func NewHtpasswd(filename string) (*Htpasswd, error) {
+	h := &Htpasswd{
+		Filename:      filename,
+		CheckInterval: 5 * time.Second,
+	}
+	if err := h.Reload(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// Reload re-reads the htpasswd file.
+func (h *Htpasswd) Reload() error {
+	f, err := os.Open(h.Filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	users := map[string]string{}
+	var dummy string
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		user, hash, ok := strings.Cut(line, ":")
+		if !ok || user == "" {
+			return fmt.Errorf("%s:%d: malformed htpasswd entry", h.Filename, lineNo)
+		}
+		users[user] = hash
+		if dummy == "" {
+			dummy = hash
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.users, h.dummy = users, dummy
+	h.watch.loaded(info)
+	return nil
+}
+
+// Authenticate checks the password of the provided user against their entry in the file.
+func (h *Htpasswd) Authenticate(user string, password string) bool {
+	if h.watch.changed(h.Filename, h.CheckInterval) {
+		h.Reload()
+	}
+	h.mu.RLock()
+	hash, ok := h.users[user]
+	dummy := h.dummy
+	h.mu.RUnlock()
+	if !ok {
+		// Unknown users are checked against another entry so their timing doesn't differ,
+		// which would reveal which users exist.
+		h.verify(dummy, password)
+		return false
+	}
+	return h.verify(hash, password)
+}
+
+func (h *Htpasswd) verify(hash string, password string) bool {
+	switch {
+	case strings.HasPrefix(hash, "{SHA}"):
+		sum := sha1.Sum([]byte(password))
+		return secureCompare(hash[len("{SHA}"):], base64.StdEncoding.EncodeToString(sum[:]))
+	case strings.HasPrefix(hash, "{SSHA}"):
+		decoded, err := base64.StdEncoding.DecodeString(hash[len("{SSHA}"):])
+		if err != nil || len(decoded) <= sha1.Size {
+			return false
+		}
+		salt := decoded[sha1.Size:]
+		sum := sha1.Sum(append([]byte(password), salt...))
+		return subtle.ConstantTimeCompare(sum[:], decoded[:sha1.Size]) == 1
+	case strings.HasPrefix(hash, "$apr1$"), strings.HasPrefix(hash, "$1$"):
+		magic := hash[:strings.Index(hash[1:], "$")+2]
+		salt, _, ok := strings.Cut(hash[len(magic):], "$")
+		if !ok {
+			return false
+		}
+		return secureCompare(hash, md5Crypt(password, salt, magic))
+	case strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"), strings.HasPrefix(hash, "$2y$"):
+		return h.Bcrypt != nil && h.Bcrypt(hash, password)
+	case strings.HasPrefix(hash, "$"), strings.HasPrefix(hash, "{"):
+		// Hashes in formats that aren't supported, such as SHA-crypt, must not be taken as plaintext
+		// or the hash itself would be accepted as the password.
+		return false
+	}
+	return secureCompare(hash, password)
+}
+
+// secureCompare compares strings in constant time. They are hashed first
+// so the time taken doesn't leak their lengths either.
+func secureCompare(a string, b string) bool {
+	ha, hb := sha256.Sum256([]byte(a)), sha256.Sum256([]byte(b))
+	return subtle.ConstantTimeCompare(ha[:], hb[:]) == 1
+}
+
+const cryptAlphabet = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// md5Crypt implements the MD5 based crypt algorithm used for both
+// Apache's apr1 hashes and the "$1$" hashes of crypt(3).
+func md5Crypt(password string, salt string, magic string) string {
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+	pw := []byte(password)
+	d := md5.New()
+	d.Write(pw)
+	d.Write([]byte(magic))
+	d.Write([]byte(salt))
+
+	alt := md5.New()
+	alt.Write(pw)
+	alt.Write([]byte(salt))
+	alt.Write(pw)
+	final := alt.Sum(nil)
+	for i := len(pw); i > 0; i -= 16 {
+		d.Write(final[:min(i, 16)])
+	}
+	for i := len(pw); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			d.Write([]byte{0})
+		} else {
+			d.Write(pw[:1])
+		}
+	}
+	final = d.Sum(nil)
+
+	for i := 0; i < 1000; i++ {
+		round := md5.New()
+		if i&1 != 0 {
+			round.Write(pw)
+		} else {
+			round.Write(final)
+		}
+		if i%3 != 0 {
+			round.Write([]byte(salt))
+		}
+		if i%7 != 0 {
+			round.Write(pw)
+		}
+		if i&1 != 0 {
+			round.Write(final)
+		} else {
+			round.Write(pw)
+		}
+		final = round.Sum(nil)
+	}
+
+	var b strings.Builder
+	b.WriteString(magic)
+	b.WriteString(salt)
+	b.WriteString("$")
+	encode := func(v uint32, n int) {
+		for ; n > 0; n-- {
+			b.WriteByte(cryptAlphabet[v&0x3f])
+			v >>= 6
+		}
+	}
+	encode(uint32(final[0])<<16|uint32(final[6])<<8|uint32(final[12]), 4)
+	encode(uint32(final[1])<<16|uint32(final[7])<<8|uint32(final[13]), 4)
+	encode(uint32(final[2])<<16|uint32(final[8])<<8|uint32(final[14]), 4)
+	encode(uint32(final[3])<<16|uint32(final[9])<<8|uint32(final[15]), 4)
+	encode(uint32(final[4])<<16|uint32(final[10])<<8|uint32(final[5]), 4)
+	encode(uint32(final[11]), 2)
+	return b.String()
+}
@@ -0,0 +1,121 @@
+package entre
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testHtpasswd = `# Users for testing
+sha:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=
+ssha:{SSHA}rXVtWiPAY6/w8MuTLKIjpBjj2mtzYWx0MTIzNA==
+apr1:$apr1$r31ManyP$J/ul.2gwu0a9Hs6tkWbm1.
+md5:$1$saltsalt$qjXMvbEw8oaL.CzflDtaK/
+plain:password
+bcrypt:$2y$05$abcdefghijklmnopqrstuu5s2v8.iXieOjg/.AySBTTZIIVFJeBui
+`
+
+func writeHtpasswd(t *testing.T, content string) string {
+	name := filepath.Join(t.TempDir(), ".htpasswd")
+	if err := os.WriteFile(name, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func Test_HtpasswdFormats(t *testing.T) {
+	h, err := NewHtpasswd(writeHtpasswd(t, testHtpasswd))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, user := range []string{"sha", "ssha", "apr1", "md5", "plain"} {
+		expect(t, h.Authenticate(user, "password"), true)
+		expect(t, h.Authenticate(user, "wrong"), false)
+	}
+	expect(t, h.Authenticate("unknown", "password"), false)
+	// bcrypt entries need a verifier to be plugged in.
+	expect(t, h.Authenticate("bcrypt", "password"), false)
+	h.Bcrypt = func(hash string, password string) bool {
+		return hash == "$2y$05$abcdefghijklmnopqrstuu5s2v8.iXieOjg/.AySBTTZIIVFJeBui" && password == "password"
+	}
+	expect(t, h.Authenticate("bcrypt", "password"), true)
+}
+
+func Test_HtpasswdUnknownFormats(t *testing.T) {
+	hashes := []string{
+		"$5$rounds=5000$saltsalt$Gbg9rL1rYXbPj7xb0oUnDbFiAnlPL4Ygq8Pf7PWKqO1",
+		"$6$saltsalt$qFmFH.bQmmtXzyBY0s9v7Oicd2z4XSIecDzlB5KiA2/jctKu9YterLp8wwnSq.qc.eoxqOmSuNp2xS0ktL3nh/",
+		"$2x$05$abcdefghijklmnopqrstuu5s2v8.iXieOjg/.AySBTTZIIVFJeBui",
+		"{SHA512}ZGVhZGJlZWY=",
+	}
+	content := ""
+	for i, hash := range hashes {
+		content += fmt.Sprintf("user%d:%s\n", i, hash)
+	}
+	h, err := NewHtpasswd(writeHtpasswd(t, content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The hash itself must not be accepted as if it was a plaintext password.
+	for i, hash := range hashes {
+		expect(t, h.Authenticate(fmt.Sprintf("user%d", i), hash), false)
+	}
+}
+
+func Test_HtpasswdUnknownUser(t *testing.T) {
+	h, err := NewHtpasswd(writeHtpasswd(t, "bcrypt:$2y$05$abcdefghijklmnopqrstuu5s2v8.iXieOjg/.AySBTTZIIVFJeBui\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var verified int
+	h.Bcrypt = func(hash string, password string) bool {
+		verified++
+		return true
+	}
+	// Unknown users still have a hash verified so they take as long as known ones.
+	expect(t, h.Authenticate("nobody", "password"), false)
+	expect(t, verified, 1)
+}
+
+func Test_HtpasswdReload(t *testing.T) {
+	name := writeHtpasswd(t, "plain:password\n")
+	h, err := NewHtpasswd(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.CheckInterval = 0
+	expect(t, h.Authenticate("plain", "password"), true)
+	if err := os.WriteFile(name, []byte("plain:changed\nnew:user\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// Make sure the change is picked up on filesystems with coarse timestamps.
+	os.Chtimes(name, time.Now().Add(time.Minute), time.Now().Add(time.Minute))
+	expect(t, h.Authenticate("plain", "password"), false)
+	expect(t, h.Authenticate("plain", "changed"), true)
+	expect(t, h.Authenticate("new", "user"), true)
+}
+
+func Test_HtpasswdMalformed(t *testing.T) {
+	_, err := NewHtpasswd(writeHtpasswd(t, "missing-separator\n"))
+	refute(t, err, nil)
+}
+
+func Test_BasicAuthHtpasswd(t *testing.T) {
+	h, err := NewHtpasswd(writeHtpasswd(t, testHtpasswd))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := New(NewBasicAuthWithStore(h))
+	req, err := http.NewRequest("GET", "http://localhost:8384/test", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	req.SetBasicAuth("apr1", "password")
+	recorder := httptest.NewRecorder()
+	e.ServeHTTP(recorder, req)
+	expect(t, recorder.Code, http.StatusOK)
+}
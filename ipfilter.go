@@ -0,0 +1,242 @@
+package entre
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+type clientIPKey struct{}
+
+// IPFilter provides the middleware which allows or denies requests by the IP address of the client.
+// Requests from addresses in any of the Deny networks are denied, then when there are Allow networks
+// requests from addresses outside all of them are denied too. Denied requests are responded to
+// with a 403 Forbidden or by OnDenied.
+//
+// When the request comes from one of the TrustedProxies the client's address is taken from the
+// X-Forwarded-For header, walking it from the right past any other trusted proxies. The address
+// resolved is available to the rest of the chain, see ClientIPFrom.
+type IPFilter struct {
+	// Allow are the networks requests are allowed from, any network when empty.
+	Allow []netip.Prefix
+	// Deny are the networks requests are denied from.
+	Deny []netip.Prefix
+	// TrustedProxies are the networks of the proxies trusted to forward the client's address.
+	TrustedProxies []netip.Prefix
+	// Filename is the path of a file holding further networks, one per line, such as:
+	//
+	//	# Office and VPN
+	//	allow 203.0.113.0/24
+	//	allow 2001:db8::/32
+	//	deny 203.0.113.66
+	//
+	// The file is reloaded when it changes.
+	Filename string
+	// CheckInterval is how often the file is checked for changes.
+	CheckInterval time.Duration
+	// OnDenied writes the response to denied requests, when set.
+	OnDenied func(w http.ResponseWriter, r *http.Request)
+
+	mu        sync.RWMutex
+	fileAllow []netip.Prefix
+	fileDeny  []netip.Prefix
+	watch     fileWatch
+}
+
+// NewIPFilter creates a new IP filter allowing and denying the provided networks,
+// which are CIDRs such as "10.0.0.0/8" or "2001:db8::/32" or single addresses.
+func NewIPFilter(allow []string, deny []string) (*IPFilter, error) {
+	allowed, err := ParseCIDRs(allow...)
+	if err != nil {
+		return nil, err
+	}
+	denied, err := ParseCIDRs(deny...)
+	if err != nil {
+		return nil, err
+	}
+	return &IPFilter{Allow: allowed, Deny: denied, CheckInterval: 5 * time.Second}, nil
+}
+
+// NewIPFilterFromFile creates a new IP filter with the networks in the provided file,
+// which is checked for changes at most every 5 seconds, see IPFilter.Filename.
+func NewIPFilterFromFile(filename string) (*IPFilter, error) {
+	f := &IPFilter{Filename: filename, CheckInterval: 5 * time.Second}
+	if err := f.Reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// ParseCIDRs parses the provided networks, which are CIDRs or single addresses.
+func ParseCIDRs(cidrs ...string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		prefix, err := parseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes, nil
+}
+
+func parseCIDR(cidr string) (netip.Prefix, error) {
+	cidr = strings.TrimSpace(cidr)
+	if strings.Contains(cidr, "/") {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		// Client addresses are unmapped so IPv4 networks mapped into IPv6 are too.
+		if addr := prefix.Addr(); addr.Is4In6() && prefix.Bits() >= 96 {
+			prefix = netip.PrefixFrom(addr.Unmap(), prefix.Bits()-96)
+		}
+		return prefix.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(cidr)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+func (f *IPFilter) ServeHTTP(w http.ResponseWriter, r *http.Request, ps httprouter.Params, next http.HandlerFunc) {
+	ip, ok := f.resolve(r)
+	if ok {
+		r = r.WithContext(context.WithValue(r.Context(), clientIPKey{}, ip.String()))
+	}
+	if !ok || !f.allowed(ip) {
+		if f.OnDenied != nil {
+			f.OnDenied(w, r)
+			return
+		}
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	next(w, r)
+}
+
+// resolve provides the client's address, reporting false when it can't be determined.
+func (f *IPFilter) resolve(r *http.Request) (netip.Addr, bool) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	addr = addr.Unmap().WithZone("")
+	if !containsAddr(f.TrustedProxies, addr) {
+		return addr, true
+	}
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			// The client can't be trusted to be anyone in particular beyond a malformed hop.
+			return netip.Addr{}, false
+		}
+		addr = hop.Unmap().WithZone("")
+		if !containsAddr(f.TrustedProxies, addr) {
+			return addr, true
+		}
+	}
+	return addr, true
+}
+
+func (f *IPFilter) allowed(ip netip.Addr) bool {
+	if f.Filename != "" && f.watch.changed(f.Filename, f.CheckInterval) {
+		f.Reload()
+	}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if containsAddr(f.Deny, ip) || containsAddr(f.fileDeny, ip) {
+		return false
+	}
+	if len(f.Allow) == 0 && len(f.fileAllow) == 0 {
+		return true
+	}
+	return containsAddr(f.Allow, ip) || containsAddr(f.fileAllow, ip)
+}
+
+// Reload re-reads the networks in the file.
+func (f *IPFilter) Reload() error {
+	file, err := os.Open(f.Filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	var allow, deny []netip.Prefix
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return fmt.Errorf("%s:%d: malformed entry", f.Filename, lineNo)
+		}
+		prefix, err := parseCIDR(fields[1])
+		if err != nil {
+			return fmt.Errorf("%s:%d: %w", f.Filename, lineNo, err)
+		}
+		switch action := fields[0]; action {
+		case "allow":
+			allow = append(allow, prefix)
+		case "deny":
+			deny = append(deny, prefix)
+		default:
+			return fmt.Errorf("%s:%d: unknown action %q", f.Filename, lineNo, action)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.fileAllow, f.fileDeny = allow, deny
+	f.watch.loaded(info)
+	return nil
+}
+
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIPFrom provides the IP address of the client which made the request.
+// This is the address resolved through trusted proxies when the request is being served
+// by the IP filter middleware, otherwise the address the request came from.
+func ClientIPFrom(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey{}).(string); ok {
+		return ip
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,183 @@
+package entre
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func serveFrom(e *Entre, remoteAddr string, forwardedFor ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "/admin", nil)
+	req.RemoteAddr = remoteAddr
+	for _, hop := range forwardedFor {
+		req.Header.Add("X-Forwarded-For", hop)
+	}
+	recorder := httptest.NewRecorder()
+	e.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func newIPFilterApp(f *IPFilter) *Entre {
+	e := New(f)
+	e.PushHandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(ClientIPFrom(r)))
+	})
+	return e
+}
+
+func Test_IPFilter(t *testing.T) {
+	f, err := NewIPFilter([]string{"10.0.0.0/8", "2001:db8::/32"}, []string{"10.0.0.66"})
+	expect(t, err, nil)
+	e := newIPFilterApp(f)
+
+	expect(t, serveFrom(e, "10.1.2.3:5000").Code, http.StatusOK)
+	expect(t, serveFrom(e, "[2001:db8::1]:5000").Code, http.StatusOK)
+	// IPv4 addresses mapped into IPv6 are matched as IPv4.
+	expect(t, serveFrom(e, "[::ffff:10.1.2.3]:5000").Code, http.StatusOK)
+	expect(t, serveFrom(e, "192.168.1.1:5000").Code, http.StatusForbidden)
+	expect(t, serveFrom(e, "[2001:db9::1]:5000").Code, http.StatusForbidden)
+	// Denied networks win over allowed ones.
+	expect(t, serveFrom(e, "10.0.0.66:5000").Code, http.StatusForbidden)
+	// Without trusted proxies forwarded addresses are ignored.
+	expect(t, serveFrom(e, "192.168.1.1:5000", "10.1.2.3").Code, http.StatusForbidden)
+
+	_, err = NewIPFilter([]string{"10.0.0.0/33"}, nil)
+	refute(t, err, nil)
+
+	// IPv4 networks mapped into IPv6 match IPv4 addresses.
+	f, err = NewIPFilter([]string{"::ffff:192.0.2.0/120"}, nil)
+	expect(t, err, nil)
+	expect(t, f.Allow[0].String(), "192.0.2.0/24")
+	e = newIPFilterApp(f)
+	expect(t, serveFrom(e, "192.0.2.1:5000").Code, http.StatusOK)
+	expect(t, serveFrom(e, "[::ffff:192.0.2.1]:5000").Code, http.StatusOK)
+	expect(t, serveFrom(e, "198.51.100.1:5000").Code, http.StatusForbidden)
+}
+
+func Test_IPFilterTrustedProxies(t *testing.T) {
+	f, err := NewIPFilter([]string{"203.0.113.0/24"}, nil)
+	expect(t, err, nil)
+	f.TrustedProxies, err = ParseCIDRs("10.0.0.0/8", "fd00::/8")
+	expect(t, err, nil)
+	e := newIPFilterApp(f)
+
+	recorder := serveFrom(e, "10.0.0.1:5000", "203.0.113.7")
+	expect(t, recorder.Code, http.StatusOK)
+	expect(t, recorder.Body.String(), "203.0.113.7")
+	// Addresses are taken from the right, skipping trusted proxies, so clients can't spoof them.
+	recorder = serveFrom(e, "10.0.0.1:5000", "203.0.113.8, 198.51.100.1, 203.0.113.7", "fd00::2")
+	expect(t, recorder.Code, http.StatusOK)
+	expect(t, recorder.Body.String(), "203.0.113.7")
+	expect(t, serveFrom(e, "10.0.0.1:5000", "203.0.113.7, 198.51.100.1").Code, http.StatusForbidden)
+	// A proxy forwarding nothing is the client itself.
+	expect(t, serveFrom(e, "10.0.0.1:5000").Code, http.StatusForbidden)
+	expect(t, serveFrom(e, "10.0.0.1:5000", "not-an-ip").Code, http.StatusForbidden)
+}
+
+func Test_IPFilterAuthLockout(t *testing.T) {
+	for name, test := range map[string]struct {
+		auth func(lockout *Lockout, onFailure func(r *http.Request, failure *AuthFailure)) Handler
+		fail func(e *Entre, req *http.Request, user string)
+	}{
+		"basic": {
+			auth: func(lockout *Lockout, onFailure func(r *http.Request, failure *AuthFailure)) Handler {
+				ba := NewBasicAuth("user", "password")
+				ba.Lockout, ba.OnFailure = lockout, onFailure
+				return ba
+			},
+			fail: func(e *Entre, req *http.Request, user string) {
+				req.SetBasicAuth(user, "wrong")
+			},
+		},
+		"apikey": {
+			auth: func(lockout *Lockout, onFailure func(r *http.Request, failure *AuthFailure)) Handler {
+				a := NewAPIKeyAuth(NewMemoryKeyStore())
+				a.Lockout, a.OnFailure = lockout, onFailure
+				return a
+			},
+			fail: func(e *Entre, req *http.Request, user string) {
+				req.Header.Set("Authorization", "Bearer wrong-key-for-"+user)
+			},
+		},
+		"digest": {
+			auth: func(lockout *Lockout, onFailure func(r *http.Request, failure *AuthFailure)) Handler {
+				d := NewDigestAuth(Users{"user": "password"})
+				d.Lockout, d.OnFailure = lockout, onFailure
+				return d
+			},
+			fail: func(e *Entre, req *http.Request, user string) {
+				challenge := serveDigest(e, "/admin", "").Header().Get("WWW-Authenticate")
+				req.Header.Set("Authorization", digestAuthorization(challenge, "GET", "/admin", user, "wrong", 1))
+			},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			f, _ := NewIPFilter(nil, nil)
+			f.TrustedProxies, _ = ParseCIDRs("10.0.0.0/8")
+			lockout := NewLockout(1)
+			lockout.BaseDelay = time.Minute
+			var failures []*AuthFailure
+			e := New(f, test.auth(lockout, func(r *http.Request, failure *AuthFailure) {
+				failures = append(failures, failure)
+			}))
+			serve := func(client string, user string) int {
+				req := httptest.NewRequest("GET", "/admin", nil)
+				req.RemoteAddr = "10.0.0.1:5000"
+				req.Header.Set("X-Forwarded-For", client)
+				test.fail(e, req, user)
+				recorder := httptest.NewRecorder()
+				e.ServeHTTP(recorder, req)
+				return recorder.Code
+			}
+
+			// Behind a trusted proxy failures are reported and locked out by the client's address, not the proxy's.
+			expect(t, serve("203.0.113.7", "user"), http.StatusUnauthorized)
+			expect(t, failures[0].RemoteIP, "203.0.113.7")
+			expect(t, lockout.Locked("ip:203.0.113.7") > 0, true)
+			expect(t, lockout.Locked("ip:10.0.0.1"), time.Duration(0))
+			expect(t, serve("203.0.113.8", "other"), http.StatusUnauthorized)
+			expect(t, failures[1].RemoteIP, "203.0.113.8")
+			expect(t, serve("203.0.113.7", "another"), http.StatusTooManyRequests)
+		})
+	}
+}
+
+func Test_IPFilterOnDenied(t *testing.T) {
+	f, _ := NewIPFilter([]string{"127.0.0.1"}, nil)
+	f.OnDenied = func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Not available from "+ClientIPFrom(r), http.StatusNotFound)
+	}
+	recorder := serveFrom(newIPFilterApp(f), "192.0.2.1:5000")
+	expect(t, recorder.Code, http.StatusNotFound)
+	expect(t, recorder.Body.String(), "Not available from 192.0.2.1\n")
+}
+
+func Test_IPFilterFromFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "ips")
+	os.WriteFile(filename, []byte("# Office\nallow 203.0.113.0/24\ndeny 203.0.113.66\n"), 0600)
+	f, err := NewIPFilterFromFile(filename)
+	expect(t, err, nil)
+	f.CheckInterval = 0
+	e := newIPFilterApp(f)
+	expect(t, serveFrom(e, "203.0.113.7:5000").Code, http.StatusOK)
+	expect(t, serveFrom(e, "203.0.113.66:5000").Code, http.StatusForbidden)
+	expect(t, serveFrom(e, "198.51.100.1:5000").Code, http.StatusForbidden)
+
+	// Changes to the file are picked up.
+	os.WriteFile(filename, []byte("allow 198.51.100.0/24\n"), 0600)
+	os.Chtimes(filename, time.Now().Add(time.Minute), time.Now().Add(time.Minute))
+	expect(t, serveFrom(e, "198.51.100.1:5000").Code, http.StatusOK)
+	expect(t, serveFrom(e, "203.0.113.7:5000").Code, http.StatusForbidden)
+
+	// A broken file keeps the networks loaded last.
+	os.WriteFile(filename, []byte("permit 203.0.113.0/24\n"), 0600)
+	os.Chtimes(filename, time.Now().Add(2*time.Minute), time.Now().Add(2*time.Minute))
+	expect(t, serveFrom(e, "198.51.100.1:5000").Code, http.StatusOK)
+	expect(t, f.Reload() == nil, false)
+
+	_, err = NewIPFilterFromFile(filepath.Join(t.TempDir(), "missing"))
+	refute(t, err, nil)
+}
@@ -0,0 +1,232 @@
+package entre
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// JWKS provides JWT keys from a JSON Web Key Set fetched from a URL, such as an
+// identity provider's jwks_uri. Keys are cached for CacheDuration and the set is
+// fetched again early when a token uses a key ID that isn't known yet, so keys can be
+// rotated, though no more often than MinRefreshInterval.
+// RSA, P-256 EC, Ed25519 OKP and symmetric oct keys are supported.
+type JWKS struct {
+	// URL is where the key set is fetched from.
+	URL string
+	// Client is the HTTP client used to fetch the key set.
+	Client *http.Client
+	// CacheDuration is how long a fetched key set is used for.
+	CacheDuration time.Duration
+	// MinRefreshInterval is the least amount of time between fetches of the key set.
+	MinRefreshInterval time.Duration
+
+	mu       sync.Mutex
+	keys     map[string]*jwk
+	fetched  time.Time
+	fetching *jwksFetch
+}
+
+// jwksFetch is a fetch of the key set in progress, which requests needing it wait on.
+type jwksFetch struct {
+	done chan struct{}
+	err  error
+}
+
+type jwk struct {
+	alg string
+	key interface{}
+}
+
+// NewJWKS creates a new key set fetched from the provided URL, which is
+// cached for an hour and fetched at most once every 30 seconds.
+func NewJWKS(url string) *JWKS {
+	return &JWKS{
+		URL:                url,
+		Client:             &http.Client{Timeout: 10 * time.Second},
+		CacheDuration:      time.Hour,
+		MinRefreshInterval: 30 * time.Second,
+	}
+}
+
+// Key provides the key with the provided ID, fetching the key set when the cached one
+// is stale or doesn't contain the key. The previously fetched keys carry on being
+// used when the key set can't be fetched or while it is being fetched again.
+func (j *JWKS) Key(kid string, alg string) (interface{}, error) {
+	j.mu.Lock()
+	since := time.Since(j.fetched)
+	k, ok := j.lookup(kid)
+	refresh := (!ok && since >= j.MinRefreshInterval) || (since >= j.CacheDuration && j.fetching == nil)
+	j.mu.Unlock()
+	if refresh {
+		if err := j.refresh(); err != nil && !ok {
+			return nil, err
+		}
+		j.mu.Lock()
+		k, ok = j.lookup(kid)
+		j.mu.Unlock()
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown key ID %q", kid)
+	}
+	if k.alg != "" && k.alg != alg {
+		return nil, fmt.Errorf("key %q is for %s not %s", kid, k.alg, alg)
+	}
+	return k.key, nil
+}
+
+func (j *JWKS) lookup(kid string) (*jwk, bool) {
+	if k, ok := j.keys[kid]; ok {
+		return k, true
+	}
+	if kid == "" && len(j.keys) == 1 {
+		for _, k := range j.keys {
+			return k, true
+		}
+	}
+	return nil, false
+}
+
+// refresh fetches the key set without holding the lock, so requests with cached keys aren't held up.
+// Requests which need the key set while it is being fetched wait for that fetch rather than starting another.
+func (j *JWKS) refresh() error {
+	j.mu.Lock()
+	if fetch := j.fetching; fetch != nil {
+		j.mu.Unlock()
+		<-fetch.done
+		return fetch.err
+	}
+	fetch := &jwksFetch{done: make(chan struct{})}
+	j.fetching = fetch
+	j.fetched = time.Now()
+	j.mu.Unlock()
+
+	keys, err := j.fetch()
+	j.mu.Lock()
+	if err == nil {
+		j.keys = keys
+	}
+	j.fetching = nil
+	j.mu.Unlock()
+	fetch.err = err
+	close(fetch.done)
+	return err
+}
+
+func (j *JWKS) fetch() (map[string]*jwk, error) {
+	client := j.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Get(j.URL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching JWKS from %s: unexpected status %s", j.URL, resp.Status)
+	}
+	keys, err := parseJWKS(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("parsing JWKS from %s: %w", j.URL, err)
+	}
+	return keys, nil
+}
+
+// parseJWKS parses a JSON Web Key Set, skipping keys which aren't used for
+// signatures, aren't supported or are invalid so one bad key doesn't take the others down with it.
+func parseJWKS(r io.Reader) (map[string]*jwk, error) {
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			Alg string `json:"alg"`
+			Crv string `json:"crv"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+			K   string `json:"k"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(r).Decode(&set); err != nil {
+		return nil, err
+	}
+	keys := map[string]*jwk{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		var key interface{}
+		var err error
+		switch {
+		case k.Kty == "RSA":
+			key, err = parseRSAJWK(k.N, k.E)
+		case k.Kty == "EC" && k.Crv == "P-256":
+			key, err = parseECJWK(k.X, k.Y)
+		case k.Kty == "OKP" && k.Crv == "Ed25519":
+			var x []byte
+			x, err = base64.RawURLEncoding.DecodeString(k.X)
+			if err == nil && len(x) != ed25519.PublicKeySize {
+				err = errors.New("invalid Ed25519 key size")
+			}
+			key = ed25519.PublicKey(x)
+		case k.Kty == "oct":
+			key, err = base64.RawURLEncoding.DecodeString(k.K)
+		default:
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = &jwk{alg: k.Alg, key: key}
+	}
+	return keys, nil
+}
+
+func parseRSAJWK(n string, e string) (*rsa.PublicKey, error) {
+	nb, err := base64.RawURLEncoding.DecodeString(n)
+	if err != nil {
+		return nil, err
+	}
+	eb, err := base64.RawURLEncoding.DecodeString(e)
+	if err != nil {
+		return nil, err
+	}
+	exponent := new(big.Int).SetBytes(eb)
+	if len(nb) < 256 || !exponent.IsInt64() || exponent.Int64() < 3 || exponent.Int64() > 1<<31-1 {
+		return nil, errors.New("invalid RSA key")
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(nb), E: int(exponent.Int64())}, nil
+}
+
+func parseECJWK(x string, y string) (*ecdsa.PublicKey, error) {
+	xb, err := base64.RawURLEncoding.DecodeString(x)
+	if err != nil {
+		return nil, err
+	}
+	yb, err := base64.RawURLEncoding.DecodeString(y)
+	if err != nil {
+		return nil, err
+	}
+	if len(xb) != 32 || len(yb) != 32 {
+		return nil, errors.New("invalid P-256 key size")
+	}
+	// Make sure the point is on the curve before using it.
+	if _, err := ecdh.P256().NewPublicKey(append(append([]byte{4}, xb...), yb...)); err != nil {
+		return nil, err
+	}
+	return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(xb), Y: new(big.Int).SetBytes(yb)}, nil
+}
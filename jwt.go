@@ -0,0 +1,288 @@
+package entre
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// Errors returned when verifying a JWT.
+var (
+	ErrTokenMalformed = errors.New("malformed token")
+	ErrTokenSignature = errors.New("invalid token signature")
+	ErrTokenExpired   = errors.New("token has expired")
+	ErrTokenNotYet    = errors.New("token is not valid yet")
+	ErrTokenClaims    = errors.New("invalid token claims")
+)
+
+// JWTKeys provides the keys JWT signatures are verified with.
+type JWTKeys interface {
+	// Key provides the key for the provided key ID and algorithm, the key ID is empty
+	// when the token doesn't have one. Keys are []byte for HS256, *rsa.PublicKey for RS256,
+	// *ecdsa.PublicKey for ES256 and ed25519.PublicKey for EdDSA.
+	Key(kid string, alg string) (interface{}, error)
+}
+
+// StaticKeys is a fixed set of JWT keys keyed by key ID.
+// When a token has no key ID and there is only one key it is used.
+type StaticKeys map[string]interface{}
+
+// Key provides the key with the provided ID.
+func (k StaticKeys) Key(kid string, alg string) (interface{}, error) {
+	if key, ok := k[kid]; ok {
+		return key, nil
+	}
+	if kid == "" && len(k) == 1 {
+		for _, key := range k {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown key ID %q", kid)
+}
+
+// JWTAuth provides the JWT bearer token authentication middleware.
+// It verifies HS256, RS256, ES256 and EdDSA signatures and checks the exp, nbf, iss and aud claims.
+// The sub claim becomes the ID of the authenticated principal, the scope and roles claims its roles
+// and the full set of claims is available as the principal's claims.
+type JWTAuth struct {
+	// Keys provides the keys signatures are verified with, such as StaticKeys or a JWKS.
+	Keys JWTKeys
+	// Algorithms are the signing algorithms accepted.
+	Algorithms []string
+	// Issuer is the required iss claim, not checked when empty.
+	Issuer string
+	// Audience is the audience the aud claim must contain, not checked when empty.
+	Audience string
+	// Leeway is the clock skew allowed for when checking the exp and nbf claims.
+	Leeway time.Duration
+	// RequireExpiry determines whether or not tokens without an exp claim are rejected.
+	RequireExpiry bool
+	// Header is the header the token is sent in.
+	Header string
+	// Scheme is the auth scheme the header value must start with.
+	Scheme string
+	// Cookie is the cookie the token can be sent in.
+	Cookie string
+	// Realm is the protection space sent to clients in the WWW-Authenticate header.
+	Realm string
+	// OnFailure is called for each failed attempt, when set.
+	OnFailure func(r *http.Request, failure *AuthFailure)
+}
+
+// NewJWTAuth creates a new JWT auth instance which expects bearer tokens in
+// the Authorization header signed with one of the provided keys.
+// Tokens must expire and a minute of clock skew is allowed for.
+func NewJWTAuth(keys JWTKeys) *JWTAuth {
+	return &JWTAuth{
+		Keys:          keys,
+		Algorithms:    []string{"HS256", "RS256", "ES256", "EdDSA"},
+		Leeway:        time.Minute,
+		RequireExpiry: true,
+		Header:        "Authorization",
+		Scheme:        "Bearer",
+		Realm:         "Restricted",
+	}
+}
+
+func (j *JWTAuth) ServeHTTP(w http.ResponseWriter, r *http.Request, ps httprouter.Params, next http.HandlerFunc) {
+	token := credentialFrom(r, j.Header, j.Scheme, "", j.Cookie)
+	if token == "" {
+		challengeBearer(w, j.Scheme, j.Realm, "")
+		return
+	}
+	claims, err := j.Verify(token)
+	if err != nil {
+		if j.OnFailure != nil {
+			reason := "invalid credentials"
+			if errors.Is(err, ErrTokenExpired) {
+				reason = "expired credentials"
+			}
+			failure := newAuthFailure(r, "", reason, 0)
+			failure.Err = err
+			j.OnFailure(r, failure)
+		}
+		challengeBearer(w, j.Scheme, j.Realm, "invalid_token")
+		return
+	}
+	next(w, WithPrincipal(r, principalFromClaims(claims, "jwt")))
+}
+
+// Verify verifies the signature and claims of the provided token, providing its claims.
+func (j *JWTAuth) Verify(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrTokenMalformed
+	}
+	var header struct {
+		Alg  string   `json:"alg"`
+		Kid  string   `json:"kid"`
+		Crit []string `json:"crit"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, err
+	}
+	if !containsString(j.Algorithms, header.Alg) {
+		return nil, fmt.Errorf("%w: algorithm %q isn't accepted", ErrTokenMalformed, header.Alg)
+	}
+	if len(header.Crit) > 0 {
+		return nil, fmt.Errorf("%w: unsupported critical headers %v", ErrTokenMalformed, header.Crit)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrTokenMalformed
+	}
+	if j.Keys == nil {
+		return nil, fmt.Errorf("%w: no keys configured", ErrTokenSignature)
+	}
+	key, err := j.Keys.Key(header.Kid, header.Alg)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrTokenSignature, err)
+	}
+	if err := verifyJWTSignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return nil, err
+	}
+	claims := map[string]interface{}{}
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	if err := j.checkClaims(claims, time.Now()); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (j *JWTAuth) checkClaims(claims map[string]interface{}, now time.Time) error {
+	exp, hasExp := claims["exp"].(float64)
+	if !hasExp && (j.RequireExpiry || claims["exp"] != nil) {
+		return fmt.Errorf("%w: missing or invalid exp", ErrTokenClaims)
+	}
+	if hasExp && now.Add(-j.Leeway).After(unixTime(exp)) {
+		return ErrTokenExpired
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(j.Leeway).Before(unixTime(nbf)) {
+		return ErrTokenNotYet
+	}
+	if j.Issuer != "" && claims["iss"] != j.Issuer {
+		return fmt.Errorf("%w: unexpected issuer %v", ErrTokenClaims, claims["iss"])
+	}
+	if j.Audience != "" && !containsString(stringsClaim(claims["aud"]), j.Audience) {
+		return fmt.Errorf("%w: unexpected audience %v", ErrTokenClaims, claims["aud"])
+	}
+	return nil
+}
+
+// principalFromClaims creates a principal from token claims.
+func principalFromClaims(claims map[string]interface{}, authMethod string) *Principal {
+	p := &Principal{Claims: claims, AuthMethod: authMethod}
+	p.ID, _ = claims["sub"].(string)
+	for _, claim := range []string{"name", "preferred_username", "email", "sub"} {
+		if name, ok := claims[claim].(string); ok && name != "" {
+			p.Name = name
+			break
+		}
+	}
+	if scope, ok := claims["scope"].(string); ok {
+		p.Roles = append(p.Roles, strings.Fields(scope)...)
+	}
+	p.Roles = append(p.Roles, stringsClaim(claims["roles"])...)
+	return p
+}
+
+// verifyJWTSignature verifies the signature of a token, making sure the key
+// is of the type expected for the algorithm so one can't be used in place of another.
+func verifyJWTSignature(alg string, key interface{}, input []byte, signature []byte) error {
+	hash := sha256.Sum256(input)
+	switch alg {
+	case "HS256":
+		if secret, ok := key.([]byte); ok {
+			mac := hmac.New(sha256.New, secret)
+			mac.Write(input)
+			if hmac.Equal(mac.Sum(nil), signature) {
+				return nil
+			}
+			return ErrTokenSignature
+		}
+	case "RS256":
+		if pub, ok := key.(*rsa.PublicKey); ok {
+			if rsa.VerifyPKCS1v15(pub, crypto.SHA256, hash[:], signature) == nil {
+				return nil
+			}
+			return ErrTokenSignature
+		}
+	case "ES256":
+		if pub, ok := key.(*ecdsa.PublicKey); ok && pub.Curve == elliptic.P256() {
+			if len(signature) == 64 {
+				r, s := new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])
+				if ecdsa.Verify(pub, hash[:], r, s) {
+					return nil
+				}
+			}
+			return ErrTokenSignature
+		}
+	case "EdDSA":
+		if pub, ok := key.(ed25519.PublicKey); ok {
+			if ed25519.Verify(pub, input, signature) {
+				return nil
+			}
+			return ErrTokenSignature
+		}
+	default:
+		return fmt.Errorf("%w: unsupported algorithm %q", ErrTokenMalformed, alg)
+	}
+	return fmt.Errorf("%w: key of type %T can't be used with %s", ErrTokenSignature, key, alg)
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return ErrTokenMalformed
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return ErrTokenMalformed
+	}
+	return nil
+}
+
+// stringsClaim provides a claim which is either a single string or an array of strings as a slice.
+func stringsClaim(claim interface{}) []string {
+	switch v := claim.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, value := range v {
+			if s, ok := value.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+func unixTime(seconds float64) time.Time {
+	return time.Unix(int64(seconds), 0)
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,272 @@
+package entre
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// signJWT creates a token for testing signed with the provided private key.
+func signJWT(t *testing.T, alg string, kid string, key interface{}, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	hash := sha256.Sum256([]byte(input))
+	var signature []byte
+	var err error
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(input))
+		signature = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		signature, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, hash[:])
+	case *ecdsa.PrivateKey:
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, k, hash[:])
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	case ed25519.PrivateKey:
+		signature = ed25519.Sign(k, []byte(input))
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func testClaims(extra map[string]interface{}) map[string]interface{} {
+	claims := map[string]interface{}{
+		"sub":   "user-1",
+		"name":  "Jane",
+		"iss":   "https://issuer.example.com",
+		"aud":   []string{"api"},
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"scope": "read write",
+	}
+	for k, v := range extra {
+		claims[k] = v
+	}
+	return claims
+}
+
+func Test_JWTAlgorithms(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	edPub, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	secret := []byte("a-very-secret-hmac-key")
+	auth := NewJWTAuth(StaticKeys{
+		"hs": secret,
+		"rs": &rsaKey.PublicKey,
+		"es": &ecKey.PublicKey,
+		"ed": edPub,
+	})
+	auth.Issuer = "https://issuer.example.com"
+	auth.Audience = "api"
+	for _, tc := range []struct {
+		alg string
+		kid string
+		key interface{}
+	}{
+		{"HS256", "hs", secret},
+		{"RS256", "rs", rsaKey},
+		{"ES256", "es", ecKey},
+		{"EdDSA", "ed", edKey},
+	} {
+		claims, err := auth.Verify(signJWT(t, tc.alg, tc.kid, tc.key, testClaims(nil)))
+		expect(t, err, nil)
+		expect(t, claims["sub"], "user-1")
+	}
+	// A key can't be used with an algorithm it isn't meant for.
+	_, err := auth.Verify(signJWT(t, "HS256", "rs", secret, testClaims(nil)))
+	expect(t, errors.Is(err, ErrTokenSignature), true)
+	_, err = auth.Verify(signJWT(t, "none", "hs", secret, testClaims(nil)))
+	expect(t, errors.Is(err, ErrTokenMalformed), true)
+	token := signJWT(t, "HS256", "hs", secret, testClaims(nil))
+	_, err = auth.Verify(token[:len(token)-2] + "AA")
+	expect(t, errors.Is(err, ErrTokenSignature), true)
+}
+
+func Test_JWTClaims(t *testing.T) {
+	secret := []byte("a-very-secret-hmac-key")
+	auth := NewJWTAuth(StaticKeys{"": secret})
+	auth.Issuer = "https://issuer.example.com"
+	auth.Audience = "api"
+	auth.Leeway = time.Minute
+	verify := func(extra map[string]interface{}) error {
+		_, err := auth.Verify(signJWT(t, "HS256", "", secret, testClaims(extra)))
+		return err
+	}
+	expect(t, verify(nil), nil)
+	expect(t, errors.Is(verify(map[string]interface{}{"exp": time.Now().Add(-2 * time.Minute).Unix()}), ErrTokenExpired), true)
+	// Within the allowed clock skew.
+	expect(t, verify(map[string]interface{}{"exp": time.Now().Add(-30 * time.Second).Unix()}), nil)
+	expect(t, errors.Is(verify(map[string]interface{}{"nbf": time.Now().Add(2 * time.Minute).Unix()}), ErrTokenNotYet), true)
+	expect(t, verify(map[string]interface{}{"nbf": time.Now().Add(30 * time.Second).Unix()}), nil)
+	expect(t, errors.Is(verify(map[string]interface{}{"iss": "https://evil.example.com"}), ErrTokenClaims), true)
+	expect(t, errors.Is(verify(map[string]interface{}{"aud": "other"}), ErrTokenClaims), true)
+	expect(t, verify(map[string]interface{}{"aud": "api"}), nil)
+	expect(t, errors.Is(verify(map[string]interface{}{"exp": nil}), ErrTokenClaims), true)
+}
+
+func Test_JWTAuth(t *testing.T) {
+	secret := []byte("a-very-secret-hmac-key")
+	var principal *Principal
+	var failures []*AuthFailure
+	auth := NewJWTAuth(StaticKeys{"hs": secret})
+	auth.OnFailure = func(r *http.Request, failure *AuthFailure) {
+		failures = append(failures, failure)
+	}
+	e := New(auth)
+	e.PushHandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal = PrincipalFrom(r)
+	})
+	serve := func(token string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("GET", "http://localhost:8384/test", nil)
+		if err != nil {
+			t.Error(err)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		recorder := httptest.NewRecorder()
+		e.ServeHTTP(recorder, req)
+		return recorder
+	}
+	expect(t, serve("").Code, http.StatusUnauthorized)
+	expired := serve(signJWT(t, "HS256", "hs", secret, testClaims(map[string]interface{}{"exp": time.Now().Add(-time.Hour).Unix()})))
+	expect(t, expired.Code, http.StatusUnauthorized)
+	expect(t, expired.Header().Get("WWW-Authenticate"), `Bearer realm="Restricted", error="invalid_token"`)
+	expect(t, len(failures), 1)
+	expect(t, failures[0].Reason, "expired credentials")
+	expect(t, errors.Is(failures[0].Err, ErrTokenExpired), true)
+
+	expect(t, serve(signJWT(t, "HS256", "hs", secret, testClaims(map[string]interface{}{"roles": []string{"admin"}}))).Code, http.StatusOK)
+	expect(t, principal.ID, "user-1")
+	expect(t, principal.Name, "Jane")
+	expect(t, principal.AuthMethod, "jwt")
+	expect(t, principal.HasRole("read"), true)
+	expect(t, principal.HasRole("write"), true)
+	expect(t, principal.HasRole("admin"), true)
+	expect(t, principal.Claims["iss"], "https://issuer.example.com")
+}
+
+func Test_JWKS(t *testing.T) {
+	first, _ := rsa.GenerateKey(rand.Reader, 2048)
+	second, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	edPub, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	var fetches int32
+	var rotated atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		keys := []map[string]string{{
+			"kty": "RSA", "kid": "first", "use": "sig", "alg": "RS256",
+			"n": base64.RawURLEncoding.EncodeToString(first.N.Bytes()),
+			"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(first.E)).Bytes()),
+		}, {
+			"kty": "OKP", "kid": "ed", "crv": "Ed25519",
+			"x": base64.RawURLEncoding.EncodeToString(edPub),
+		}, {
+			"kty": "RSA", "kid": "encryption", "use": "enc", "n": "", "e": "",
+		}, {
+			// Invalid keys are skipped without rejecting the rest of the set.
+			"kty": "RSA", "kid": "short", "n": "AQAB", "e": "AQAB",
+		}, {
+			"kty": "EC", "kid": "off-curve", "crv": "P-256", "x": "AQAB", "y": "AQAB",
+		}}
+		if rotated.Load() {
+			keys = append(keys, map[string]string{
+				"kty": "EC", "kid": "second", "crv": "P-256",
+				"x": base64.RawURLEncoding.EncodeToString(second.X.FillBytes(make([]byte, 32))),
+				"y": base64.RawURLEncoding.EncodeToString(second.Y.FillBytes(make([]byte, 32))),
+			})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+	}))
+	defer server.Close()
+
+	jwks := NewJWKS(server.URL)
+	jwks.MinRefreshInterval = 0
+	auth := NewJWTAuth(jwks)
+	_, err := auth.Verify(signJWT(t, "RS256", "first", first, testClaims(nil)))
+	expect(t, err, nil)
+	_, err = auth.Verify(signJWT(t, "EdDSA", "ed", edKey, testClaims(nil)))
+	expect(t, err, nil)
+	// Cached keys are used without fetching the key set again.
+	expect(t, atomic.LoadInt32(&fetches), int32(1))
+	// The key is only meant for RS256.
+	_, err = auth.Verify(signJWT(t, "HS256", "first", []byte("secret"), testClaims(nil)))
+	expect(t, errors.Is(err, ErrTokenSignature), true)
+
+	// An unknown key ID makes the key set be fetched again, picking up the rotated key.
+	_, err = auth.Verify(signJWT(t, "ES256", "second", second, testClaims(nil)))
+	expect(t, errors.Is(err, ErrTokenSignature), true)
+	rotated.Store(true)
+	_, err = auth.Verify(signJWT(t, "ES256", "second", second, testClaims(nil)))
+	expect(t, err, nil)
+	expect(t, atomic.LoadInt32(&fetches), int32(3))
+
+	// Refreshes are rate limited.
+	jwks.MinRefreshInterval = time.Hour
+	_, err = auth.Verify(signJWT(t, "ES256", "unknown", second, testClaims(nil)))
+	expect(t, errors.Is(err, ErrTokenSignature), true)
+	expect(t, atomic.LoadInt32(&fetches), int32(3))
+}
+
+func Test_JWKSConcurrentRefresh(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	var fetches int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&fetches, 1) > 1 {
+			<-release
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "EC", "kid": "key", "crv": "P-256",
+			"x": base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+			"y": base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+		}}})
+	}))
+	defer server.Close()
+
+	jwks := NewJWKS(server.URL)
+	jwks.MinRefreshInterval = 0
+	_, err := jwks.Key("key", "ES256")
+	expect(t, err, nil)
+
+	// Requests for an unknown key share the one fetch in progress.
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			jwks.Key("unknown", "ES256")
+		}()
+	}
+	for atomic.LoadInt32(&fetches) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	// Cached keys are still provided while the key set is being fetched.
+	_, err = jwks.Key("key", "ES256")
+	expect(t, err, nil)
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	expect(t, atomic.LoadInt32(&fetches), int32(2))
+}
@@ -0,0 +1,169 @@
+package entre
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxLockoutEntries caps how many keys a Lockout keeps track of, the keys which failed
+// least recently are forgotten beyond it.
+const maxLockoutEntries = 10000
+
+// Lockout throttles repeated authentication failures. Failures are counted per key,
+// such as a client IP or username, and once a key has failed MaxFailures times it is
+// locked out for BaseDelay, doubling with every further failure up to MaxDelay.
+// Failures are forgotten once a key hasn't failed for Window.
+type Lockout struct {
+	// MaxFailures is the number of failures allowed before a key is locked out.
+	MaxFailures int
+	// BaseDelay is how long a key is locked out for after reaching MaxFailures.
+	BaseDelay time.Duration
+	// MaxDelay caps how long a key can be locked out for.
+	MaxDelay time.Duration
+	// Window is how long failures are remembered for after the last one.
+	Window time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*lockoutEntry
+	pruned  time.Time
+}
+
+type lockoutEntry struct {
+	failures int
+	last     time.Time
+	until    time.Time
+}
+
+// NewLockout creates a lockout which locks keys out after maxFailures failures,
+// starting at one second and backing off exponentially up to 15 minutes.
+func NewLockout(maxFailures int) *Lockout {
+	return &Lockout{
+		MaxFailures: maxFailures,
+		BaseDelay:   time.Second,
+		MaxDelay:    15 * time.Minute,
+		Window:      15 * time.Minute,
+		entries:     map[string]*lockoutEntry{},
+	}
+}
+
+// Locked provides how much longer the longest locked out of the provided keys
+// is locked out for, 0 when none of them are locked out.
+func (l *Lockout) Locked(keys ...string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	var remaining time.Duration
+	for _, key := range keys {
+		if entry, ok := l.entries[key]; ok {
+			remaining = max(remaining, entry.until.Sub(now))
+		}
+	}
+	return remaining
+}
+
+// Fail records a failure for each of the provided keys and provides how long
+// the longest locked out of them is now locked out for.
+func (l *Lockout) Fail(keys ...string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.entries == nil {
+		l.entries = map[string]*lockoutEntry{}
+	}
+	now := time.Now()
+	l.prune(now)
+	var remaining time.Duration
+	for _, key := range keys {
+		entry, ok := l.entries[key]
+		if !ok || (now.Sub(entry.last) > l.Window && now.After(entry.until)) {
+			if !ok && len(l.entries) >= maxLockoutEntries {
+				l.evict()
+			}
+			entry = &lockoutEntry{}
+			l.entries[key] = entry
+		}
+		entry.failures++
+		entry.last = now
+		if l.MaxFailures > 0 && entry.failures >= l.MaxFailures {
+			delay := l.BaseDelay
+			for i := l.MaxFailures; i < entry.failures && i-l.MaxFailures < 32 && (l.MaxDelay <= 0 || delay < l.MaxDelay); i++ {
+				delay *= 2
+			}
+			if l.MaxDelay > 0 {
+				delay = min(delay, l.MaxDelay)
+			}
+			entry.until = now.Add(delay)
+		}
+		remaining = max(remaining, entry.until.Sub(now))
+	}
+	return remaining
+}
+
+// Reset forgets the failures of the provided keys.
+func (l *Lockout) Reset(keys ...string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, key := range keys {
+		delete(l.entries, key)
+	}
+}
+
+// prune forgets keys which are no longer locked out and haven't failed within the window,
+// this is done once a minute at most.
+func (l *Lockout) prune(now time.Time) {
+	if now.Sub(l.pruned) < time.Minute {
+		return
+	}
+	l.pruned = now
+	for key, entry := range l.entries {
+		if now.Sub(entry.last) > l.Window && now.After(entry.until) {
+			delete(l.entries, key)
+		}
+	}
+}
+
+// evict forgets the keys which failed least recently, leaving a tenth of maxLockoutEntries
+// to spare so keys aren't evicted one at a time on every failure.
+func (l *Lockout) evict() {
+	keys := make([]string, 0, len(l.entries))
+	for key := range l.entries {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return l.entries[keys[i]].last.Before(l.entries[keys[j]].last)
+	})
+	for _, key := range keys[:len(keys)-maxLockoutEntries*9/10] {
+		delete(l.entries, key)
+	}
+}
+
+// AuthFailure describes a failed authentication attempt for auditing.
+// The authentication middleware pass one to their OnFailure callback, when set,
+// for each attempt they reject, including those rejected while locked out.
+type AuthFailure struct {
+	Time time.Time
+	// User is the username provided by the client.
+	User string
+	// RemoteIP is the IP address of the client.
+	RemoteIP  string
+	RequestID string
+	// Reason describes why the attempt failed, such as "invalid credentials",
+	// "expired credentials" or "locked out".
+	Reason string
+	// LockedFor is how much longer the client is locked out for, if at all.
+	LockedFor time.Duration
+	// Err is the error the credentials were rejected with, when there is one.
+	Err error
+}
+
+func newAuthFailure(r *http.Request, user string, reason string, lockedFor time.Duration) *AuthFailure {
+	return &AuthFailure{
+		Time:      time.Now(),
+		User:      user,
+		RemoteIP:  ClientIPFrom(r),
+		RequestID: RequestIDFrom(r),
+		Reason:    reason,
+		LockedFor: max(lockedFor, 0),
+	}
+}
@@ -0,0 +1,49 @@
+package entre
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func Test_LockoutBackoff(t *testing.T) {
+	l := NewLockout(3)
+	l.BaseDelay = time.Minute
+	l.MaxDelay = 3 * time.Minute
+	expect(t, l.Fail("ip:1.2.3.4"), time.Duration(0))
+	expect(t, l.Fail("ip:1.2.3.4"), time.Duration(0))
+	expect(t, l.Locked("ip:1.2.3.4"), time.Duration(0))
+	first := l.Fail("ip:1.2.3.4")
+	expect(t, first > 59*time.Second && first <= time.Minute, true)
+	second := l.Fail("ip:1.2.3.4")
+	expect(t, second > 119*time.Second && second <= 2*time.Minute, true)
+	capped := l.Fail("ip:1.2.3.4", "user:bob")
+	expect(t, capped > 179*time.Second && capped <= 3*time.Minute, true)
+	expect(t, l.Locked("user:bob"), time.Duration(0))
+	expect(t, l.Locked("user:bob", "ip:1.2.3.4") > 0, true)
+	l.Reset("ip:1.2.3.4")
+	expect(t, l.Locked("ip:1.2.3.4"), time.Duration(0))
+}
+
+func Test_LockoutCap(t *testing.T) {
+	l := NewLockout(1)
+	l.Fail("user:oldest")
+	for i := 0; i < maxLockoutEntries; i++ {
+		l.Fail(fmt.Sprintf("ip:%d", i))
+	}
+	// The key which failed least recently is forgotten to make room.
+	expect(t, len(l.entries) <= maxLockoutEntries, true)
+	expect(t, l.Locked("user:oldest"), time.Duration(0))
+	expect(t, l.Locked(fmt.Sprintf("ip:%d", maxLockoutEntries-1)) > 0, true)
+}
+
+func Test_LockoutPrune(t *testing.T) {
+	l := NewLockout(5)
+	l.Window = time.Millisecond
+	l.Fail("ip:1.2.3.4")
+	time.Sleep(5 * time.Millisecond)
+	// Stale keys are forgotten on the next failure once the pruning interval has passed.
+	l.pruned = time.Time{}
+	l.Fail("ip:5.6.7.8")
+	expect(t, len(l.entries), 1)
+}
@@ -1,7 +1,10 @@
 package entre
 
 import (
+	"context"
+	"io"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"time"
@@ -16,20 +19,116 @@ type LoggerIface interface {
 	Printf(string, ...interface{})
 }
 
+type slogKey struct{}
+
 // Logger is the type which provides our core logging middleware.
 type Logger struct {
 	LoggerIface
+	// Slog is the structured logger request-scoped loggers are derived from,
+	// slog.Default() is used when nil.
+	Slog *slog.Logger
+	// Body enables logging of request and response bodies when set.
+	// This is intended for debugging and is disabled by default.
+	Body *BodyLogging
+	// LogUser adds the name of the authenticated principal to the completed line
+	// when an auth middleware later in the chain authenticated the request.
+	LogUser bool
 }
 
 // NewLogger creates a new logger middleware instance.
 func NewLogger() *Logger {
-	return &Logger{log.New(os.Stdout, "|-entre-|", 0)}
+	return &Logger{LoggerIface: log.New(os.Stdout, "|-entre-|", 0)}
+}
+
+// SetOutput points the logger at the provided writer, such as a RotatingFile.
+func (l *Logger) SetOutput(w io.Writer) {
+	l.LoggerIface = log.New(w, "|-entre-|", 0)
 }
 
 func (l *Logger) ServeHTTP(w http.ResponseWriter, r *http.Request, ps httprouter.Params, next http.HandlerFunc) {
 	startTime := time.Now()
-	l.Printf("Began %s %s", r.Method, r.URL.Path)
-	next(w, r)
-	resp := NewResponse(w)
-	l.Printf("Completed with %v %s response in %v", resp.Status(), http.StatusText(resp.Status()), time.Since(startTime))
+	prefix := requestIDLogPrefix(r)
+	if l.LogUser {
+		r = trackPrincipal(r)
+	}
+	logf(l.LoggerIface, SeverityInfo, "%sBegan %s %s", prefix, r.Method, r.URL.Path)
+	resp := responseFor(w)
+	if l.Body != nil {
+		resp = l.logRequestDetails(resp, r, prefix)
+	}
+	next(resp, l.withRequestLogger(r))
+	if capture, ok := resp.(*bodyCaptureResponse); ok {
+		l.logResponseDetails(capture, prefix)
+	}
+	user := ""
+	if usr := principalName(r); l.LogUser && usr != "" {
+		user = " for " + usr
+	}
+	logf(l.LoggerIface, SeverityInfo, "%sCompleted with %v %s response%s in %v", prefix, resp.Status(), http.StatusText(resp.Status()), user, time.Since(startTime))
+}
+
+// logRequestDetails logs the redacted headers, query and body of the request
+// and provides a response which captures the body written to it.
+func (l *Logger) logRequestDetails(resp Response, r *http.Request, prefix string) Response {
+	logf(l.LoggerIface, SeverityDebug, "%sRequest headers: %s", prefix, l.Body.formatHeaders(r.Header))
+	if r.URL.RawQuery != "" {
+		logf(l.LoggerIface, SeverityDebug, "%sRequest query: %s", prefix, l.Body.formatQuery(r.URL.RawQuery))
+	}
+	if body, ok := l.Body.captureRequestBody(r); ok {
+		logf(l.LoggerIface, SeverityDebug, "%sRequest body: %s", prefix, l.Body.formatBody(r.Header.Get("Content-Type"), body))
+	}
+	return &bodyCaptureResponse{Response: resp, max: l.Body.MaxBytes}
+}
+
+func (l *Logger) logResponseDetails(capture *bodyCaptureResponse, prefix string) {
+	logf(l.LoggerIface, SeverityDebug, "%sResponse headers: %s", prefix, l.Body.formatHeaders(capture.Header()))
+	contentType := capture.Header().Get("Content-Type")
+	if capture.buf.Len() > 0 && l.Body.allowed(contentType) {
+		logf(l.LoggerIface, SeverityDebug, "%sResponse body: %s", prefix, l.Body.formatBody(contentType, capture.buf.Bytes()))
+	}
+}
+
+// withRequestLogger stores a structured logger carrying the attributes
+// of the provided request in the request context.
+func (l *Logger) withRequestLogger(r *http.Request) *http.Request {
+	base := l.Slog
+	if base == nil {
+		base = slog.Default()
+	}
+	attrs := []any{}
+	if id := RequestIDFrom(r); id != "" {
+		attrs = append(attrs, slog.String("request_id", id))
+	}
+	attrs = append(attrs, slog.String("method", r.Method), slog.String("path", r.URL.Path))
+	return r.WithContext(context.WithValue(r.Context(), slogKey{}, base.With(attrs...)))
+}
+
+// LoggerFrom provides a structured logger for the provided request.
+// When the logging middleware is part of the stack the logger it injected
+// is used, otherwise one is derived from slog.Default().
+// The route and authenticated user are added when they are known at the time of calling,
+// so this should be called from the handler rather than ahead of time.
+func LoggerFrom(r *http.Request) *slog.Logger {
+	if r == nil {
+		return slog.Default()
+	}
+	logger, ok := r.Context().Value(slogKey{}).(*slog.Logger)
+	attrs := []any{}
+	if !ok {
+		logger = slog.Default()
+		if id := RequestIDFrom(r); id != "" {
+			attrs = append(attrs, slog.String("request_id", id))
+		}
+		attrs = append(attrs, slog.String("method", r.Method), slog.String("path", r.URL.Path))
+	}
+	if route := RouteFrom(r); route != "" {
+		attrs = append(attrs, slog.String("route", route))
+	}
+	if usr := principalName(r); usr != "" {
+		attrs = append(attrs, slog.String("user", usr))
+	}
+	if len(attrs) == 0 {
+		return logger
+	}
+	return logger.With(attrs...)
 }
@@ -2,9 +2,12 @@ package entre
 
 import (
 	"bytes"
+	"io"
 	"log"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 )
 
@@ -26,3 +29,109 @@ func Test_Logger(t *testing.T) {
 	expect(t, recorder.Code, http.StatusNotFound)
 	refute(t, len(buf.String()), 0)
 }
+
+func Test_LoggerFrom(t *testing.T) {
+	buf := bytes.NewBufferString("")
+	l := NewLogger()
+	l.LoggerIface = log.New(bytes.NewBufferString(""), "|-entre-|", 0)
+	l.Slog = slog.New(slog.NewTextHandler(buf, nil))
+	e := New(NewRequestID(), l, NewBasicAuth("user", "password"), UseRoute("/users/:id"))
+	e.PushHandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		LoggerFrom(r).Info("loading user")
+	})
+	req, err := http.NewRequest("GET", "http://localhost:8384/users/1", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	req.SetBasicAuth("user", "password")
+	req.Header.Set("X-Request-ID", "my-request-id")
+	e.ServeHTTP(httptest.NewRecorder(), req)
+	out := buf.String()
+	expect(t, strings.Contains(out, `msg="loading user"`), true)
+	expect(t, strings.Contains(out, "request_id=my-request-id"), true)
+	expect(t, strings.Contains(out, "method=GET"), true)
+	expect(t, strings.Contains(out, "path=/users/1"), true)
+	expect(t, strings.Contains(out, "route=/users/:id"), true)
+	expect(t, strings.Contains(out, "user=user"), true)
+}
+
+func Test_LoggerFromWithoutLogger(t *testing.T) {
+	req, err := http.NewRequest("POST", "http://localhost:8384/test", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	expect(t, LoggerFrom(req) != nil, true)
+	expect(t, LoggerFrom(nil), slog.Default())
+}
+
+func Test_LoggerStatus(t *testing.T) {
+	buf := bytes.NewBufferString("")
+	l := NewLogger()
+	l.LoggerIface = log.New(buf, "|-entre-|", 0)
+	e := New(l)
+	e.PushHandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	req, err := http.NewRequest("GET", "http://localhost:8384/test", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	e.ServeHTTP(httptest.NewRecorder(), req)
+	expect(t, strings.Contains(buf.String(), "Completed with 418 I'm a teapot response"), true)
+}
+
+func Test_LoggerBodyLogging(t *testing.T) {
+	buf := bytes.NewBufferString("")
+	l := NewLogger()
+	l.LoggerIface = log.New(buf, "|-entre-|", 0)
+	l.Body = NewBodyLogging()
+	var received string
+	e := New(l)
+	e.PushHandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received = string(body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Set-Cookie", "session=supersecretcookie")
+		w.Write([]byte(`{"id":1,"access_token":"supersecrettoken"}`))
+	})
+	reqBody := `{"user":"bob","password":"supersecretpassword","nested":[{"token":"supersecrettoken"}]}`
+	req, err := http.NewRequest("POST", "http://localhost:8384/test?api_key=supersecretkey&page=2", strings.NewReader(reqBody))
+	if err != nil {
+		t.Error(err)
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set("Authorization", "Bearer supersecrettoken")
+	e.ServeHTTP(httptest.NewRecorder(), req)
+	out := buf.String()
+	// Downstream handlers must still get the complete body.
+	expect(t, received, reqBody)
+	expect(t, strings.Contains(out, "supersecret"), false)
+	expect(t, strings.Contains(out, `"user":"bob"`), true)
+	expect(t, strings.Contains(out, "page=2"), true)
+	expect(t, strings.Contains(out, `Authorization="[REDACTED]"`), true)
+	expect(t, strings.Contains(out, `Response body: {"access_token":"[REDACTED]","id":1}`), true)
+}
+
+func Test_LoggerBodyLoggingLimits(t *testing.T) {
+	buf := bytes.NewBufferString("")
+	l := NewLogger()
+	l.LoggerIface = log.New(buf, "|-entre-|", 0)
+	l.Body = NewBodyLogging()
+	l.Body.MaxBytes = 16
+	e := New(l)
+	e.PushHandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("not in the allowlist"))
+	})
+	req, err := http.NewRequest("POST", "http://localhost:8384/test", strings.NewReader("password=supersecret&name=averyveryverylongname"))
+	if err != nil {
+		t.Error(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	e.ServeHTTP(httptest.NewRecorder(), req)
+	out := buf.String()
+	expect(t, strings.Contains(out, "supersecret"), false)
+	expect(t, strings.Contains(out, "password=[REDACTED]"), true)
+	expect(t, strings.Contains(out, "[truncated]"), true)
+	expect(t, strings.Contains(out, "not in the allowlist"), false)
+}
@@ -0,0 +1,368 @@
+package entre
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// Session values used by the OIDC middleware.
+const (
+	oidcClaimsKey = "_oidc_claims"
+	oidcFlowsKey  = "_oidc_flows"
+)
+
+// oidcSessionClaims are the ID token claims kept in the session.
+var oidcSessionClaims = []string{"sub", "name", "preferred_username", "email", "roles"}
+
+// maxOIDCFlows caps how many logins a session can have in progress at once, such as in several tabs,
+// the oldest being forgotten beyond it.
+const maxOIDCFlows = 5
+
+// OIDC provides the OpenID Connect relying party middleware for single sign-on.
+// It must come after the sessions middleware, see Sessions. Requests without an identity
+// in their session are sent to the identity provider to log in using the authorization code flow
+// with PKCE, unsafe requests are responded to with a 401 Unauthorized instead.
+// The provider redirects back to the callback, where the state is checked, the code is exchanged
+// and the ID token and its nonce are verified before the identity is stored in the session.
+// Only the sub, name, preferred_username, email and roles claims of the ID token are stored.
+// The identity is available to the rest of the chain as a principal, see PrincipalFrom.
+type OIDC struct {
+	// Issuer is the identity provider's issuer URL, its configuration is discovered
+	// from the /.well-known/openid-configuration document beneath it.
+	Issuer string
+	// ClientID and ClientSecret are the credentials of the application registered with the provider.
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the absolute URL of the callback registered with the provider,
+	// requests for its path are handled as callbacks.
+	RedirectURL string
+	// Scopes are the scopes requested.
+	Scopes []string
+	// Client is the HTTP client used to talk to the provider.
+	Client *http.Client
+	// Logger is where errors talking to the provider are logged to, when set.
+	Logger LoggerIface
+	// MinRetryInterval is the least amount of time between attempts to fetch the
+	// discovery document after one has failed, logins fail with the same error meanwhile.
+	MinRetryInterval time.Duration
+
+	mu          sync.Mutex
+	config      *oidcConfig
+	verifier    *JWTAuth
+	discovering *oidcDiscovery
+	failed      time.Time
+	failure     error
+}
+
+// oidcDiscovery is a fetch of the discovery document in progress, which logins wait on.
+type oidcDiscovery struct {
+	done     chan struct{}
+	config   *oidcConfig
+	verifier *JWTAuth
+	err      error
+}
+
+// oidcConfig is the part of the provider's discovery document used.
+type oidcConfig struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// oidcFlow is the state of a login in progress kept in the session, identified by its State.
+type oidcFlow struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	ReturnTo string `json:"return_to"`
+}
+
+// NewOIDC creates a new OIDC instance for the provided provider and client which
+// requests the openid, profile and email scopes and retries failed discovery at most every 30 seconds.
+func NewOIDC(issuer string, clientID string, clientSecret string, redirectURL string) *OIDC {
+	return &OIDC{
+		Issuer:           strings.TrimSuffix(issuer, "/"),
+		ClientID:         clientID,
+		ClientSecret:     clientSecret,
+		RedirectURL:      redirectURL,
+		Scopes:           []string{"openid", "profile", "email"},
+		Client:           &http.Client{Timeout: 10 * time.Second},
+		MinRetryInterval: 30 * time.Second,
+	}
+}
+
+func (o *OIDC) ServeHTTP(w http.ResponseWriter, r *http.Request, ps httprouter.Params, next http.HandlerFunc) {
+	session := SessionFrom(r)
+	if session == nil {
+		o.logError(r, "no session, the sessions middleware must come before the OIDC middleware")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if callback, err := url.Parse(o.RedirectURL); err == nil && r.URL.Path == callback.Path {
+		o.callback(w, r, session)
+		return
+	}
+	if claims, ok := session.Get(oidcClaimsKey).(map[string]interface{}); ok {
+		next(w, WithPrincipal(r, principalFromClaims(claims, "oidc")))
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	o.login(w, r, session)
+}
+
+// login starts the authorization code flow by sending the user to the provider.
+func (o *OIDC) login(w http.ResponseWriter, r *http.Request, session *Session) {
+	config, _, err := o.discover()
+	if err != nil {
+		o.logError(r, "discovering provider: %s", err)
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return
+	}
+	flow := &oidcFlow{State: randomToken(), Nonce: randomToken(), Verifier: randomToken(), ReturnTo: r.URL.RequestURI()}
+	flows := append(oidcFlows(session), flow)
+	if len(flows) > maxOIDCFlows {
+		flows = flows[len(flows)-maxOIDCFlows:]
+	}
+	setOIDCFlows(session, flows)
+	challenge := sha256.Sum256([]byte(flow.Verifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {o.ClientID},
+		"redirect_uri":          {o.RedirectURL},
+		"scope":                 {strings.Join(o.Scopes, " ")},
+		"state":                 {flow.State},
+		"nonce":                 {flow.Nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	separator := "?"
+	if strings.Contains(config.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	http.Redirect(w, r, config.AuthorizationEndpoint+separator+query.Encode(), http.StatusFound)
+}
+
+// callback completes the authorization code flow and stores the identity in the session.
+func (o *OIDC) callback(w http.ResponseWriter, r *http.Request, session *Session) {
+	flows := oidcFlows(session)
+	if len(flows) == 0 {
+		http.Error(w, "No login in progress", http.StatusBadRequest)
+		return
+	}
+	query := r.URL.Query()
+	var flow *oidcFlow
+	for i, f := range flows {
+		if subtle.ConstantTimeCompare([]byte(query.Get("state")), []byte(f.State)) == 1 {
+			flow = f
+			setOIDCFlows(session, append(flows[:i:i], flows[i+1:]...))
+			break
+		}
+	}
+	if flow == nil {
+		http.Error(w, "Invalid state", http.StatusBadRequest)
+		return
+	}
+	if errorCode := query.Get("error"); errorCode != "" {
+		o.logError(r, "provider returned error %s: %s", errorCode, query.Get("error_description"))
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	claims, err := o.exchange(query.Get("code"), flow)
+	if err != nil {
+		o.logError(r, "completing login: %s", err)
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	// Logging in is a privilege change so the session gets a new ID.
+	session.Regenerate()
+	// Only the claims the principal is built from are kept so sessions stay small enough for a cookie.
+	kept := map[string]interface{}{}
+	for _, claim := range oidcSessionClaims {
+		if value, ok := claims[claim]; ok {
+			kept[claim] = value
+		}
+	}
+	session.Set(oidcClaimsKey, kept)
+	returnTo := flow.ReturnTo
+	if !strings.HasPrefix(returnTo, "/") || strings.HasPrefix(returnTo, "//") || strings.HasPrefix(returnTo, `/\`) {
+		returnTo = "/"
+	}
+	http.Redirect(w, r, returnTo, http.StatusFound)
+}
+
+// oidcFlows provides the logins in progress in the session, oldest first.
+func oidcFlows(session *Session) []*oidcFlow {
+	var flows []*oidcFlow
+	if encoded, ok := session.Get(oidcFlowsKey).(string); ok {
+		if json.Unmarshal([]byte(encoded), &flows) != nil {
+			return nil
+		}
+	}
+	return flows
+}
+
+func setOIDCFlows(session *Session, flows []*oidcFlow) {
+	if len(flows) == 0 {
+		session.Delete(oidcFlowsKey)
+		return
+	}
+	encoded, _ := json.Marshal(flows)
+	session.Set(oidcFlowsKey, string(encoded))
+}
+
+// exchange exchanges the authorization code for tokens, providing the claims of the verified ID token.
+func (o *OIDC) exchange(code string, flow *oidcFlow) (map[string]interface{}, error) {
+	config, verifier, err := o.discover()
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {o.RedirectURL},
+		"code_verifier": {flow.Verifier},
+	}
+	req, err := http.NewRequest(http.MethodPost, config.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(o.ClientID), url.QueryEscape(o.ClientSecret))
+	resp, err := o.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token endpoint responded with %s: %s", resp.Status, body)
+	}
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.Unmarshal(body, &tokens); err != nil {
+		return nil, err
+	}
+	if tokens.IDToken == "" {
+		return nil, errors.New("no ID token in token response")
+	}
+	claims, err := verifier.Verify(tokens.IDToken)
+	if err != nil {
+		return nil, err
+	}
+	if nonce, _ := claims["nonce"].(string); subtle.ConstantTimeCompare([]byte(nonce), []byte(flow.Nonce)) != 1 {
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrTokenClaims)
+	}
+	// A token for several audiences must have been issued to this client, see OpenID Connect Core 3.1.3.7.
+	if azp, ok := claims["azp"]; ok || len(stringsClaim(claims["aud"])) > 1 {
+		if azp != o.ClientID {
+			return nil, fmt.Errorf("%w: unexpected authorized party %v", ErrTokenClaims, azp)
+		}
+	}
+	return claims, nil
+}
+
+// discover fetches the provider's discovery document the first time it is needed, without holding
+// the lock so logged in requests aren't held up. Logins which need it while it is being fetched wait for
+// that fetch rather than starting another, and a failure is returned again until MinRetryInterval has passed.
+func (o *OIDC) discover() (*oidcConfig, *JWTAuth, error) {
+	o.mu.Lock()
+	if o.config != nil {
+		defer o.mu.Unlock()
+		return o.config, o.verifier, nil
+	}
+	if fetch := o.discovering; fetch != nil {
+		o.mu.Unlock()
+		<-fetch.done
+		return fetch.config, fetch.verifier, fetch.err
+	}
+	if o.failure != nil && time.Since(o.failed) < o.MinRetryInterval {
+		defer o.mu.Unlock()
+		return nil, nil, o.failure
+	}
+	fetch := &oidcDiscovery{done: make(chan struct{})}
+	o.discovering = fetch
+	o.mu.Unlock()
+
+	fetch.config, fetch.verifier, fetch.err = o.fetchConfig()
+	o.mu.Lock()
+	if fetch.err == nil {
+		o.config, o.verifier, o.failure = fetch.config, fetch.verifier, nil
+	} else {
+		o.failed, o.failure = time.Now(), fetch.err
+	}
+	o.discovering = nil
+	o.mu.Unlock()
+	close(fetch.done)
+	return fetch.config, fetch.verifier, fetch.err
+}
+
+func (o *OIDC) fetchConfig() (*oidcConfig, *JWTAuth, error) {
+	resp, err := o.client().Get(o.Issuer + "/.well-known/openid-configuration")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("discovery document responded with %s", resp.Status)
+	}
+	config := &oidcConfig{}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(config); err != nil {
+		return nil, nil, err
+	}
+	if strings.TrimSuffix(config.Issuer, "/") != o.Issuer {
+		return nil, nil, fmt.Errorf("discovery document is for issuer %q", config.Issuer)
+	}
+	if config.AuthorizationEndpoint == "" || config.TokenEndpoint == "" || config.JWKSURI == "" {
+		return nil, nil, errors.New("discovery document is missing endpoints")
+	}
+	jwks := NewJWKS(config.JWKSURI)
+	jwks.Client = o.client()
+	verifier := NewJWTAuth(jwks)
+	// ID tokens are signed with the provider's keys, never a shared secret.
+	verifier.Algorithms = []string{"RS256", "ES256", "EdDSA"}
+	verifier.Issuer = config.Issuer
+	verifier.Audience = o.ClientID
+	return config, verifier, nil
+}
+
+func (o *OIDC) client() *http.Client {
+	if o.Client != nil {
+		return o.Client
+	}
+	return http.DefaultClient
+}
+
+func (o *OIDC) logError(r *http.Request, format string, args ...interface{}) {
+	if o.Logger != nil {
+		logf(o.Logger, SeverityError, "%sOIDC "+format, append([]interface{}{requestIDLogPrefix(r)}, args...)...)
+	}
+}
+
+// randomToken generates a random URL safe token.
+func randomToken() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,331 @@
+package entre
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testIdP is a stand-in OpenID Connect identity provider which logs everyone in as Jane.
+type testIdP struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	// claims are added to the ID tokens issued, replacing the defaults.
+	claims map[string]interface{}
+
+	mu    sync.Mutex
+	codes map[string]url.Values
+}
+
+func newTestIdP(t *testing.T) *testIdP {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	idp := &testIdP{key: key, claims: map[string]interface{}{}, codes: map[string]url.Values{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.server.URL,
+			"authorization_endpoint": idp.server.URL + "/authorize",
+			"token_endpoint":         idp.server.URL + "/token",
+			"jwks_uri":               idp.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA", "kid": "idp", "use": "sig",
+			"n": base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		code := randomToken()
+		idp.mu.Lock()
+		idp.codes[code] = query
+		idp.mu.Unlock()
+		callback := query.Get("redirect_uri") + "?" + url.Values{"code": {code}, "state": {query.Get("state")}}.Encode()
+		http.Redirect(w, r, callback, http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, _ := r.BasicAuth(); user != "app" || pass != "app-secret" {
+			http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+			return
+		}
+		idp.mu.Lock()
+		authorize, ok := idp.codes[r.PostFormValue("code")]
+		delete(idp.codes, r.PostFormValue("code"))
+		idp.mu.Unlock()
+		challenge := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+		if !ok || authorize.Get("code_challenge_method") != "S256" ||
+			base64.RawURLEncoding.EncodeToString(challenge[:]) != authorize.Get("code_challenge") ||
+			r.PostFormValue("redirect_uri") != authorize.Get("redirect_uri") {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		claims := map[string]interface{}{
+			"iss":   idp.server.URL,
+			"sub":   "user-1",
+			"aud":   authorize.Get("client_id"),
+			"exp":   time.Now().Add(time.Hour).Unix(),
+			"nonce": authorize.Get("nonce"),
+			"name":  "Jane",
+			"email": "jane@example.com",
+		}
+		for k, v := range idp.claims {
+			claims[k] = v
+		}
+		json.NewEncoder(w).Encode(map[string]string{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"id_token":     signJWT(t, "RS256", "idp", key, claims),
+		})
+	})
+	idp.server = httptest.NewServer(mux)
+	return idp
+}
+
+// authorize follows the login redirect to the provider, providing the callback it redirects back to.
+func (idp *testIdP) authorize(t *testing.T, location string) string {
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	callback, _ := url.Parse(resp.Header.Get("Location"))
+	return callback.RequestURI()
+}
+
+func newOIDCApp(idp *testIdP) (*Entre, *OIDC) {
+	oidc := NewOIDC(idp.server.URL, "app", "app-secret", "https://app.example.com/auth/callback")
+	e := New(NewSessions(testSessionKey), oidc)
+	e.PushHandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(PrincipalFrom(r).Name + " " + PrincipalFrom(r).AuthMethod))
+	})
+	return e, oidc
+}
+
+func Test_OIDCLogin(t *testing.T) {
+	idp := newTestIdP(t)
+	defer idp.server.Close()
+	e, _ := newOIDCApp(idp)
+	client := &sessionClient{t: t, e: e}
+
+	recorder := client.serve("/dashboard?tab=1")
+	expect(t, recorder.Code, http.StatusFound)
+	location, _ := url.Parse(recorder.Header().Get("Location"))
+	expect(t, location.Path, "/authorize")
+	query := location.Query()
+	expect(t, query.Get("response_type"), "code")
+	expect(t, query.Get("client_id"), "app")
+	expect(t, query.Get("scope"), "openid profile email")
+	expect(t, query.Get("code_challenge_method"), "S256")
+	refute(t, query.Get("state"), "")
+	refute(t, query.Get("nonce"), "")
+	loginCookie := client.cookie
+
+	recorder = client.serve(idp.authorize(t, location.String()))
+	expect(t, recorder.Code, http.StatusFound)
+	expect(t, recorder.Header().Get("Location"), "/dashboard?tab=1")
+	// The session is regenerated on login.
+	refute(t, client.cookie.Value, loginCookie.Value)
+
+	recorder = client.serve("/dashboard")
+	expect(t, recorder.Code, http.StatusOK)
+	expect(t, recorder.Body.String(), "Jane oidc")
+}
+
+func Test_OIDCSessionClaims(t *testing.T) {
+	idp := newTestIdP(t)
+	defer idp.server.Close()
+	idp.claims = map[string]interface{}{"roles": []string{"admin"}, "picture": strings.Repeat("x", 4096)}
+	e, _ := newOIDCApp(idp)
+	var principal *Principal
+	e.PushHandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal = PrincipalFrom(r)
+	})
+	client := &sessionClient{t: t, e: e}
+	location := client.serve("/").Header().Get("Location")
+	client.serve(idp.authorize(t, location))
+
+	// Large claims which aren't needed don't end up in the session cookie.
+	expect(t, client.serve("/").Code, http.StatusOK)
+	expect(t, principal.ID, "user-1")
+	expect(t, principal.HasRole("admin"), true)
+	expect(t, principal.Claims["email"], "jane@example.com")
+	expect(t, principal.Claims["picture"], nil)
+}
+
+func Test_OIDCCallbackChecks(t *testing.T) {
+	idp := newTestIdP(t)
+	defer idp.server.Close()
+	e, _ := newOIDCApp(idp)
+
+	// A callback without a login in progress is rejected.
+	client := &sessionClient{t: t, e: e}
+	expect(t, client.serve("/auth/callback?code=abc&state=abc").Code, http.StatusBadRequest)
+
+	// As is one with a state other than the one sent to the provider.
+	location, _ := url.Parse(client.serve("/").Header().Get("Location"))
+	callback, _ := url.Parse(idp.authorize(t, location.String()))
+	values := callback.Query()
+	values.Set("state", "forged")
+	expect(t, client.serve("/auth/callback?"+values.Encode()).Code, http.StatusBadRequest)
+
+	// The state can only be used once.
+	location, _ = url.Parse(client.serve("/").Header().Get("Location"))
+	path := idp.authorize(t, location.String())
+	expect(t, client.serve(path).Code, http.StatusFound)
+	expect(t, client.serve(path).Code, http.StatusBadRequest)
+
+	// Errors returned by the provider fail the login.
+	other := &sessionClient{t: t, e: e}
+	location, _ = url.Parse(other.serve("/").Header().Get("Location"))
+	values = url.Values{"error": {"access_denied"}, "state": {location.Query().Get("state")}}
+	expect(t, other.serve("/auth/callback?"+values.Encode()).Code, http.StatusUnauthorized)
+}
+
+func Test_OIDCConcurrentLogins(t *testing.T) {
+	idp := newTestIdP(t)
+	defer idp.server.Close()
+	e, _ := newOIDCApp(idp)
+	client := &sessionClient{t: t, e: e}
+
+	// Logins started in several tabs can each be completed.
+	first := client.serve("/first").Header().Get("Location")
+	second := client.serve("/second").Header().Get("Location")
+	expect(t, client.serve(idp.authorize(t, first)).Header().Get("Location"), "/first")
+	expect(t, client.serve(idp.authorize(t, second)).Header().Get("Location"), "/second")
+
+	// Only the most recent logins are kept.
+	other := &sessionClient{t: t, e: e}
+	oldest := other.serve("/oldest").Header().Get("Location")
+	for i := 0; i < maxOIDCFlows; i++ {
+		other.serve("/")
+	}
+	expect(t, other.serve(idp.authorize(t, oldest)).Code, http.StatusBadRequest)
+}
+
+func Test_OIDCTokenChecks(t *testing.T) {
+	for name, claims := range map[string]map[string]interface{}{
+		"nonce":    {"nonce": "replayed"},
+		"audience": {"aud": "other-app"},
+		"issuer":   {"iss": "https://evil.example.com"},
+		"expiry":   {"exp": time.Now().Add(-time.Hour).Unix()},
+		// Tokens for several audiences must have been issued to the application.
+		"authorized party":    {"aud": []string{"app", "other-app"}, "azp": "other-app"},
+		"no authorized party": {"aud": []string{"app", "other-app"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			idp := newTestIdP(t)
+			defer idp.server.Close()
+			idp.claims = claims
+			e, _ := newOIDCApp(idp)
+			client := &sessionClient{t: t, e: e}
+			location := client.serve("/").Header().Get("Location")
+			expect(t, client.serve(idp.authorize(t, location)).Code, http.StatusUnauthorized)
+			expect(t, client.serve("/").Code, http.StatusFound)
+		})
+	}
+}
+
+func Test_OIDCAuthorizedParty(t *testing.T) {
+	idp := newTestIdP(t)
+	defer idp.server.Close()
+	idp.claims = map[string]interface{}{"aud": []string{"app", "other-app"}, "azp": "app"}
+	e, _ := newOIDCApp(idp)
+	client := &sessionClient{t: t, e: e}
+	location := client.serve("/").Header().Get("Location")
+	expect(t, client.serve(idp.authorize(t, location)).Code, http.StatusFound)
+	expect(t, client.serve("/").Code, http.StatusOK)
+}
+
+func Test_OIDCUnauthenticated(t *testing.T) {
+	idp := newTestIdP(t)
+	defer idp.server.Close()
+	e, oidc := newOIDCApp(idp)
+
+	// Unsafe requests aren't redirected to log in.
+	req, _ := http.NewRequest("POST", "http://localhost:8384/items", nil)
+	recorder := httptest.NewRecorder()
+	e.ServeHTTP(recorder, req)
+	expect(t, recorder.Code, http.StatusUnauthorized)
+
+	// Logins are sent back to paths on the application only.
+	client := &sessionClient{t: t, e: e}
+	location := client.serve("//evil.example.com/").Header().Get("Location")
+	recorder = client.serve(idp.authorize(t, location))
+	expect(t, recorder.Header().Get("Location"), "/")
+
+	// A provider which can't be discovered fails the login.
+	broken := NewOIDC("http://127.0.0.1:1", "app", "app-secret", oidc.RedirectURL)
+	e = New(NewSessions(testSessionKey), broken)
+	client = &sessionClient{t: t, e: e}
+	expect(t, client.serve("/").Code, http.StatusBadGateway)
+}
+
+func Test_OIDCDiscovery(t *testing.T) {
+	var fetches int32
+	var broken atomic.Bool
+	broken.Store(true)
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		time.Sleep(50 * time.Millisecond)
+		if broken.Load() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 server.URL,
+			"authorization_endpoint": server.URL + "/authorize",
+			"token_endpoint":         server.URL + "/token",
+			"jwks_uri":               server.URL + "/jwks",
+		})
+	}))
+	defer server.Close()
+	oidc := NewOIDC(server.URL, "app", "app-secret", "https://app.example.com/auth/callback")
+	oidc.MinRetryInterval = 100 * time.Millisecond
+
+	// Logins needing the document while it is being fetched share the fetch.
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _, err := oidc.discover()
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		refute(t, err, nil)
+	}
+	expect(t, atomic.LoadInt32(&fetches), int32(1))
+
+	// The failure is returned again rather than hitting the provider on every login.
+	broken.Store(false)
+	_, _, err := oidc.discover()
+	refute(t, err, nil)
+	expect(t, atomic.LoadInt32(&fetches), int32(1))
+
+	time.Sleep(oidc.MinRetryInterval)
+	config, _, err := oidc.discover()
+	expect(t, err, nil)
+	expect(t, config.TokenEndpoint, server.URL+"/token")
+	_, _, err = oidc.discover()
+	expect(t, err, nil)
+	expect(t, atomic.LoadInt32(&fetches), int32(2))
+}
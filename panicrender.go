@@ -0,0 +1,267 @@
+package entre
+
+import (
+	"encoding/json"
+	htmltemplate "html/template"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+)
+
+// PanicDetails provides what panic renderers have to work with when producing
+// the response for a recovered panic.
+type PanicDetails struct {
+	Status    int
+	Title     string
+	ErrorID   string
+	RequestID string
+	// Panic, Stack and Frames are only provided in development mode.
+	Panic  string
+	Stack  string
+	Frames []StackFrame
+}
+
+// PanicRenderer writes the body of the response for a recovered panic.
+type PanicRenderer func(w io.Writer, d *PanicDetails) error
+
+// Template is satisfied by both html/template and text/template templates.
+type Template interface {
+	Execute(w io.Writer, data interface{}) error
+}
+
+// TemplateRenderer provides a panic renderer which executes the provided
+// template with the PanicDetails.
+func TemplateRenderer(t Template) PanicRenderer {
+	return func(w io.Writer, d *PanicDetails) error {
+		return t.Execute(w, d)
+	}
+}
+
+// The media types panic responses are rendered in by default,
+// in order of preference when the client accepts any of them.
+const (
+	mediaTypeText    = "text/plain"
+	mediaTypeJSON    = "application/json"
+	mediaTypeProblem = "application/problem+json"
+	mediaTypeHTML    = "text/html"
+)
+
+var defaultPanicMediaTypes = []string{mediaTypeText, mediaTypeJSON, mediaTypeProblem, mediaTypeHTML}
+
+var panicTextTemplate = texttemplate.Must(texttemplate.New("panic").Parse(
+	`{{.Title}}
+Error ID: {{.ErrorID}}
+{{if .RequestID}}Request ID: {{.RequestID}}
+{{end}}{{if .Panic}}
+PANIC: {{.Panic}}
+{{.Stack}}{{end}}`))
+
+var panicHTMLTemplate = htmltemplate.Must(htmltemplate.New("panic").Parse(`<!DOCTYPE html>
+<html>
+<head><title>{{.Status}} {{.Title}}</title></head>
+<body>
+<h1>{{.Title}}</h1>
+<p>Error ID: <code>{{.ErrorID}}</code></p>
+{{if .RequestID}}<p>Request ID: <code>{{.RequestID}}</code></p>
+{{end}}{{if .Panic}}<h2>PANIC: {{.Panic}}</h2>
+<pre>{{.Stack}}</pre>
+{{end}}</body>
+</html>
+`))
+
+var developerPageTemplate = htmltemplate.Must(htmltemplate.New("developer").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<title>PANIC: {{.Panic}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+h1 { color: #b00; }
+table { border-collapse: collapse; }
+td { padding: 2px 8px; vertical-align: top; font-family: monospace; }
+.frame { margin-bottom: 1.5em; }
+.function { font-weight: bold; font-family: monospace; }
+.location { color: #666; font-family: monospace; }
+pre { background: #f6f6f6; padding: 0.5em; margin: 0.3em 0; }
+.current { background: #fdd; display: block; }
+</style>
+</head>
+<body>
+<h1>PANIC: {{.Panic}}</h1>
+<p>Error ID: <code>{{.ErrorID}}</code>{{if .RequestID}} Request ID: <code>{{.RequestID}}</code>{{end}}</p>
+<h2>Stack trace</h2>
+{{range .Frames}}<div class="frame">
+<div class="function">{{.Function}}</div>
+<div class="location">{{.File}}:{{.Line}}</div>
+{{if .Source}}<pre>{{range .Source}}<span{{if .Current}} class="current"{{end}}>{{printf "%5d" .Number}}  {{.Code}}</span>
+{{end}}</pre>{{end}}
+</div>
+{{end}}{{with .Request}}<h2>Request</h2>
+<table>
+<tr><td>Method</td><td>{{.Method}}</td></tr>
+<tr><td>URL</td><td>{{.URL}}</td></tr>
+<tr><td>Protocol</td><td>{{.Proto}}</td></tr>
+<tr><td>Remote address</td><td>{{.RemoteAddr}}</td></tr>
+</table>
+<h3>Headers</h3>
+<table>
+{{range .Headers}}<tr><td>{{.Name}}</td><td>{{.Value}}</td></tr>
+{{end}}</table>
+{{end}}</body>
+</html>
+`))
+
+// developerErrorPage provides the renderer for the HTML panic response in development mode,
+// which shows source snippets around each frame and the details of the request.
+func developerErrorPage(r *http.Request) PanicRenderer {
+	return func(w io.Writer, d *PanicDetails) error {
+		type frame struct {
+			StackFrame
+			Source []SourceLine
+		}
+		type header struct {
+			Name  string
+			Value string
+		}
+		type request struct {
+			Method     string
+			URL        string
+			Proto      string
+			RemoteAddr string
+			Headers    []header
+		}
+		data := struct {
+			*PanicDetails
+			Frames  []frame
+			Request *request
+		}{PanicDetails: d}
+		for _, f := range d.Frames {
+			data.Frames = append(data.Frames, frame{f, sourceSnippet(f.File, f.Line, 5)})
+		}
+		if r != nil {
+			req := &request{Method: r.Method, URL: redactedURL(r), Proto: r.Proto, RemoteAddr: r.RemoteAddr}
+			// Credentials are left out even in development.
+			redact := NewBodyLogging()
+			for name, values := range r.Header {
+				value := strings.Join(values, ", ")
+				if containsFold(redact.RedactHeaders, name) {
+					value = redact.Replacement
+				}
+				req.Headers = append(req.Headers, header{name, value})
+			}
+			sort.Slice(req.Headers, func(i, j int) bool {
+				return req.Headers[i].Name < req.Headers[j].Name
+			})
+			data.Request = req
+		}
+		return developerPageTemplate.Execute(w, data)
+	}
+}
+
+// defaultPanicRenderers provides the renderers used for media types
+// which haven't been given a renderer of their own.
+func defaultPanicRenderers() map[string]PanicRenderer {
+	return map[string]PanicRenderer{
+		mediaTypeText:    TemplateRenderer(panicTextTemplate),
+		mediaTypeHTML:    TemplateRenderer(panicHTMLTemplate),
+		mediaTypeJSON:    renderPanicJSON,
+		mediaTypeProblem: renderPanicProblem,
+	}
+}
+
+func renderPanicJSON(w io.Writer, d *PanicDetails) error {
+	return json.NewEncoder(w).Encode(struct {
+		Error     string `json:"error"`
+		ErrorID   string `json:"error_id"`
+		RequestID string `json:"request_id,omitempty"`
+		Panic     string `json:"panic,omitempty"`
+		Stack     string `json:"stack,omitempty"`
+	}{d.Title, d.ErrorID, d.RequestID, d.Panic, d.Stack})
+}
+
+// renderPanicProblem renders an RFC 7807 problem details document.
+func renderPanicProblem(w io.Writer, d *PanicDetails) error {
+	return json.NewEncoder(w).Encode(struct {
+		Type      string `json:"type"`
+		Title     string `json:"title"`
+		Status    int    `json:"status"`
+		ErrorID   string `json:"error_id"`
+		RequestID string `json:"request_id,omitempty"`
+		Panic     string `json:"panic,omitempty"`
+		Stack     string `json:"stack,omitempty"`
+	}{"about:blank", d.Title, d.Status, d.ErrorID, d.RequestID, d.Panic, d.Stack})
+}
+
+// contentTypeFor provides the Content-Type header value for a panic response media type.
+func contentTypeFor(mediaType string) string {
+	if strings.HasPrefix(mediaType, "text/") || mediaType == mediaTypeJSON {
+		return mediaType + "; charset=utf-8"
+	}
+	return mediaType
+}
+
+// negotiate picks the offer that best matches the provided Accept header,
+// preferring offers earlier in the list when the client has no preference.
+// An empty string is returned when none of the offers are acceptable.
+func negotiate(accept string, offers []string) string {
+	best := ""
+	bestQ := 0.0
+	bestSpecificity := -1
+	for _, offer := range offers {
+		q, specificity := acceptQuality(accept, offer)
+		if q > bestQ || (q == bestQ && q > 0 && specificity > bestSpecificity) {
+			best, bestQ, bestSpecificity = offer, q, specificity
+		}
+	}
+	return best
+}
+
+// acceptQuality determines the quality value the Accept header gives to a media type,
+// along with how specific the matching range was so exact matches win over wildcards.
+func acceptQuality(accept string, mediaType string) (float64, int) {
+	q := 0.0
+	specificity := -1
+	for _, part := range strings.Split(accept, ",") {
+		rangeType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		s := -1
+		switch {
+		case rangeType == mediaType:
+			s = 2
+		case rangeType == "*/*":
+			s = 0
+		case strings.HasSuffix(rangeType, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(rangeType, "*")):
+			s = 1
+		}
+		if s < specificity || s < 0 {
+			continue
+		}
+		rangeQ := 1.0
+		if v, ok := params["q"]; ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				rangeQ = parsed
+			}
+		}
+		q, specificity = rangeQ, s
+	}
+	return q, specificity
+}
+
+// panicMediaTypes lists the media types there are renderers for,
+// the defaults first followed by any custom media types.
+func panicMediaTypes(renderers map[string]PanicRenderer) []string {
+	offers := append([]string{}, defaultPanicMediaTypes...)
+	custom := []string{}
+	for mediaType := range renderers {
+		if !containsFold(defaultPanicMediaTypes, mediaType) {
+			custom = append(custom, mediaType)
+		}
+	}
+	sort.Strings(custom)
+	return append(offers, custom...)
+}
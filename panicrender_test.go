@@ -0,0 +1,15 @@
+package entre
+
+import (
+	"testing"
+)
+
+func Test_Negotiate(t *testing.T) {
+	offers := []string{"text/plain", "application/json", "text/html"}
+	expect(t, negotiate("application/json", offers), "application/json")
+	expect(t, negotiate("text/*", offers), "text/plain")
+	expect(t, negotiate("text/*;q=0.5, text/html", offers), "text/html")
+	expect(t, negotiate("application/json;q=0.2, text/plain;q=0.9", offers), "text/plain")
+	expect(t, negotiate("*/*, application/json;q=0", offers), "text/plain")
+	expect(t, negotiate("image/png", offers), "")
+}
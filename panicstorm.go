@@ -0,0 +1,312 @@
+package entre
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxStormEntries caps how many routes and stacks a PanicStorm keeps track of at once.
+const maxStormEntries = 10000
+
+// UnroutedStorm is the route panics are tracked against for requests without a route pattern,
+// so paths chosen by clients can't each be tracked on their own. It never trips,
+// otherwise a single broken path would take every unrouted request out of service.
+const UnroutedStorm = "(unrouted)"
+
+// PanicStorm protects against routes which start panicking on every request.
+// It tracks the rate of panics per route, trips a route once it panics Threshold times
+// within Window and keeps it tripped for Cooldown. While a route is tripped its requests can
+// be rejected with a 503 and a Health readiness flag can be flipped.
+// Identical stack traces are also deduplicated in the logs of the PanicRecovery it is used with,
+// only the first occurrence within Window is logged in full along with a count of repeats.
+type PanicStorm struct {
+	// Threshold is the number of panics within Window that trips a route.
+	Threshold int
+	// Window is the period panics are counted over.
+	Window time.Duration
+	// Cooldown is how long a route stays tripped for.
+	Cooldown time.Duration
+	// Reject determines whether or not requests for tripped routes are rejected
+	// with a 503 Service Unavailable response.
+	Reject bool
+	// Health is marked as not ready while any route is tripped, when set.
+	Health *Health
+	// OnTrip is called when a route trips and when it recovers, when set.
+	OnTrip func(route string, tripped bool)
+
+	mu     sync.Mutex
+	routes map[string]*stormRoute
+	stacks map[string]*stormStack
+}
+
+type stormRoute struct {
+	panics []time.Time
+	until  time.Time
+}
+
+type stormStack struct {
+	first   time.Time
+	repeats int
+}
+
+// NewPanicStorm creates panic storm protection which trips routes that panic
+// threshold times within the window, rejecting their requests for a minute.
+func NewPanicStorm(threshold int, window time.Duration) *PanicStorm {
+	return &PanicStorm{
+		Threshold: threshold,
+		Window:    window,
+		Cooldown:  time.Minute,
+		Reject:    true,
+		routes:    map[string]*stormRoute{},
+		stacks:    map[string]*stormStack{},
+	}
+}
+
+// Tripped reports whether the provided route is currently tripped.
+func (s *PanicStorm) Tripped(route string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state, ok := s.routes[route]
+	return ok && time.Now().Before(state.until)
+}
+
+// reject writes a 503 response when the route is tripped and Reject is set,
+// reporting whether it did so.
+func (s *PanicStorm) reject(w http.ResponseWriter, route string) bool {
+	if !s.Reject || !stormTrippable(route) {
+		return false
+	}
+	s.mu.Lock()
+	state, ok := s.routes[route]
+	until := time.Time{}
+	if ok {
+		until = state.until
+	}
+	s.mu.Unlock()
+	remaining := time.Until(until)
+	if remaining <= 0 {
+		return false
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(remaining.Round(time.Second)/time.Second)+1))
+	http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+	return true
+}
+
+// record records a panic for the route with the provided stack,
+// tripping the route when it crosses the threshold. It reports how many times the same
+// stack has already been seen within the window, 0 when this is the first time.
+func (s *PanicStorm) record(route string, frames []StackFrame) int {
+	now := time.Now()
+	signature := stackSignature(frames)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.routes == nil {
+		s.routes = map[string]*stormRoute{}
+		s.stacks = map[string]*stormStack{}
+	}
+	s.prune(now)
+
+	stack, ok := s.stacks[signature]
+	if !ok || now.Sub(stack.first) > s.Window {
+		stack = &stormStack{first: now}
+		s.stacks[signature] = stack
+	} else {
+		stack.repeats++
+	}
+
+	state, ok := s.routes[route]
+	if !ok {
+		state = &stormRoute{}
+		s.routes[route] = state
+	}
+	state.panics = append(state.panics, now)
+	recent := state.panics[:0]
+	for _, t := range state.panics {
+		if now.Sub(t) <= s.Window {
+			recent = append(recent, t)
+		}
+	}
+	state.panics = recent
+	if stormTrippable(route) && s.Threshold > 0 && len(recent) >= s.Threshold && now.After(state.until) {
+		s.trip(route, state, now)
+	}
+	return stack.repeats
+}
+
+// trip trips the route and schedules it to recover once the cooldown has passed.
+func (s *PanicStorm) trip(route string, state *stormRoute, now time.Time) {
+	state.until = now.Add(s.Cooldown)
+	health := s.Health
+	if health != nil {
+		health.SetNotReady("panic-storm:"+route, fmt.Sprintf("route %s is panicking", route))
+	}
+	if s.OnTrip != nil {
+		go s.OnTrip(route, true)
+	}
+	time.AfterFunc(s.Cooldown, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if current, ok := s.routes[route]; !ok || current != state || time.Now().Before(state.until) {
+			return
+		}
+		if health != nil {
+			health.SetReady("panic-storm:" + route)
+		}
+		if s.OnTrip != nil {
+			go s.OnTrip(route, false)
+		}
+	})
+}
+
+// prune forgets stacks and routes that haven't panicked within the window
+// once more than maxStormEntries are being tracked. When that isn't enough
+// the oldest are forgotten, keeping tripped routes, to bring them back under the cap.
+func (s *PanicStorm) prune(now time.Time) {
+	if len(s.stacks) > maxStormEntries {
+		for signature, stack := range s.stacks {
+			if now.Sub(stack.first) > s.Window {
+				delete(s.stacks, signature)
+			}
+		}
+		if len(s.stacks) > maxStormEntries {
+			ages := make(map[string]time.Time, len(s.stacks))
+			for signature, stack := range s.stacks {
+				ages[signature] = stack.first
+			}
+			for _, signature := range oldestStormEntries(ages) {
+				delete(s.stacks, signature)
+			}
+		}
+	}
+	if len(s.routes) > maxStormEntries {
+		for route, state := range s.routes {
+			if now.Sub(state.last()) > s.Window && now.After(state.until) {
+				delete(s.routes, route)
+			}
+		}
+		if len(s.routes) > maxStormEntries {
+			ages := make(map[string]time.Time, len(s.routes))
+			for route, state := range s.routes {
+				ages[route] = state.last()
+				if now.Before(state.until) {
+					ages[route] = now
+				}
+			}
+			for _, route := range oldestStormEntries(ages) {
+				delete(s.routes, route)
+			}
+		}
+	}
+}
+
+func (state *stormRoute) last() time.Time {
+	if len(state.panics) == 0 {
+		return time.Time{}
+	}
+	return state.panics[len(state.panics)-1]
+}
+
+// oldestStormEntries provides the oldest of the entries with the provided ages which need to go
+// to leave a tenth of maxStormEntries to spare, so entries aren't evicted one at a time on every panic.
+func oldestStormEntries(ages map[string]time.Time) []string {
+	keys := make([]string, 0, len(ages))
+	for key := range ages {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return ages[keys[i]].Before(ages[keys[j]])
+	})
+	return keys[:len(keys)-maxStormEntries*9/10]
+}
+
+// stormRouteKey provides the route panics are tracked against, UnroutedStorm when
+// the route pattern isn't known. This is determined when the request reaches
+// the PanicRecovery middleware so requests can be rejected before they are handled.
+func stormRouteKey(r *http.Request) string {
+	if r == nil {
+		return ""
+	}
+	if route := RouteFrom(r); route != "" {
+		return route
+	}
+	return UnroutedStorm
+}
+
+// stormTrippable reports whether the route can trip, only routes with a route pattern can.
+func stormTrippable(route string) bool {
+	return route != "" && route != UnroutedStorm
+}
+
+// stackSignature identifies identical stack traces.
+func stackSignature(frames []StackFrame) string {
+	h := sha256.New()
+	for _, frame := range frames {
+		fmt.Fprintf(h, "%s|%s|%d\n", frame.Function, frame.File, frame.Line)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Health is a readiness flag which can be exposed as a health endpoint.
+// It is ready until something marks it as not ready and stays not ready
+// until every reason given for it not being ready has been cleared.
+type Health struct {
+	mu      sync.Mutex
+	reasons map[string]string
+}
+
+// NewHealth creates a new readiness flag which starts out as ready.
+func NewHealth() *Health {
+	return &Health{reasons: map[string]string{}}
+}
+
+// SetNotReady marks the flag as not ready for the reason identified by the provided key.
+func (h *Health) SetNotReady(key string, reason string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.reasons == nil {
+		h.reasons = map[string]string{}
+	}
+	h.reasons[key] = reason
+}
+
+// SetReady clears the reason identified by the provided key.
+func (h *Health) SetReady(key string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.reasons, key)
+}
+
+// Ready reports whether or not there is no reason to be not ready.
+func (h *Health) Ready() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.reasons) == 0
+}
+
+// ServeHTTP responds with 200 OK when ready and 503 Service Unavailable
+// listing the reasons otherwise, so the flag can be used as a readiness endpoint.
+func (h *Health) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	reasons := make([]string, 0, len(h.reasons))
+	for _, reason := range h.reasons {
+		reasons = append(reasons, reason)
+	}
+	h.mu.Unlock()
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if len(reasons) == 0 {
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintln(w, "ok")
+		return
+	}
+	sort.Strings(reasons)
+	w.WriteHeader(http.StatusServiceUnavailable)
+	fmt.Fprintln(w, strings.Join(reasons, "\n"))
+}
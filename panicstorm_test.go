@@ -0,0 +1,131 @@
+package entre
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_PanicStorm(t *testing.T) {
+	buf := bytes.NewBufferString("")
+	health := NewHealth()
+	r := NewPanicRecovery(false)
+	r.Logger = log.New(buf, "|-entre-|", 0)
+	r.Storm = NewPanicStorm(3, time.Minute)
+	r.Storm.Health = health
+	e := New(r)
+	e.PushHandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/broken" {
+			panic("You have caused a panic")
+		}
+	})
+	serve := func(path string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("GET", "http://localhost:8384"+path, nil)
+		if err != nil {
+			t.Error(err)
+		}
+		req.Pattern = "GET " + path
+		recorder := httptest.NewRecorder()
+		e.ServeHTTP(recorder, req)
+		return recorder
+	}
+	for i := 0; i < 3; i++ {
+		expect(t, serve("/broken").Code, http.StatusInternalServerError)
+	}
+	// The full stack is only logged the first time.
+	expect(t, strings.Count(buf.String(), "[running]"), 1)
+	expect(t, strings.Contains(buf.String(), "same stack repeated 2 times"), true)
+
+	// The route has tripped so its requests are rejected while others carry on.
+	rejected := serve("/broken")
+	expect(t, rejected.Code, http.StatusServiceUnavailable)
+	refute(t, rejected.Header().Get("Retry-After"), "")
+	expect(t, serve("/working").Code, http.StatusOK)
+	expect(t, r.Storm.Tripped("GET /broken"), true)
+
+	expect(t, health.Ready(), false)
+	recorder := httptest.NewRecorder()
+	health.ServeHTTP(recorder, nil)
+	expect(t, recorder.Code, http.StatusServiceUnavailable)
+	expect(t, strings.Contains(recorder.Body.String(), "route GET /broken is panicking"), true)
+}
+
+func Test_PanicStormUnrouted(t *testing.T) {
+	health := NewHealth()
+	r := NewPanicRecovery(false)
+	r.Logger = log.New(bytes.NewBufferString(""), "|-entre-|", 0)
+	r.Storm = NewPanicStorm(3, time.Minute)
+	r.Storm.Health = health
+	e := New(r)
+	e.PushHandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if strings.HasPrefix(req.URL.Path, "/broken") {
+			panic("You have caused a panic")
+		}
+	})
+	serve := func(path string) int {
+		req, err := http.NewRequest("GET", "http://localhost:8384"+path, nil)
+		if err != nil {
+			t.Error(err)
+		}
+		recorder := httptest.NewRecorder()
+		e.ServeHTTP(recorder, req)
+		return recorder.Code
+	}
+	for _, path := range []string{"/broken/1", "/broken/2", "/broken/3", "/broken/4"} {
+		expect(t, serve(path), http.StatusInternalServerError)
+	}
+	// Requests without a route pattern are tracked together rather than per path.
+	expect(t, len(r.Storm.routes), 1)
+	// One broken path never takes the other unrouted requests out of service.
+	expect(t, r.Storm.Tripped(UnroutedStorm), false)
+	expect(t, serve("/working"), http.StatusOK)
+	expect(t, health.Ready(), true)
+}
+
+func Test_PanicStormCap(t *testing.T) {
+	storm := NewPanicStorm(0, time.Minute)
+	for i := 0; i <= maxStormEntries; i++ {
+		storm.record(fmt.Sprintf("GET /route/%d", i), []StackFrame{{Function: "main.f", Line: i}})
+	}
+	storm.record("GET /last", []StackFrame{{Function: "main.g"}})
+	expect(t, len(storm.routes) <= maxStormEntries, true)
+	expect(t, len(storm.stacks) <= maxStormEntries, true)
+	// The oldest entries are the ones evicted.
+	_, ok := storm.routes["GET /route/0"]
+	expect(t, ok, false)
+	_, ok = storm.routes["GET /last"]
+	expect(t, ok, true)
+}
+
+func Test_PanicStormCooldown(t *testing.T) {
+	health := NewHealth()
+	tripped := make(chan bool, 2)
+	storm := NewPanicStorm(1, time.Minute)
+	storm.Cooldown = 20 * time.Millisecond
+	storm.Health = health
+	storm.OnTrip = func(route string, t bool) {
+		tripped <- t
+	}
+	storm.record("/route", nil)
+	expect(t, <-tripped, true)
+	expect(t, health.Ready(), false)
+	expect(t, <-tripped, false)
+	expect(t, health.Ready(), true)
+	expect(t, storm.Tripped("/route"), false)
+}
+
+func Test_Health(t *testing.T) {
+	health := NewHealth()
+	recorder := httptest.NewRecorder()
+	health.ServeHTTP(recorder, nil)
+	expect(t, recorder.Code, http.StatusOK)
+	health.SetNotReady("db", "database unavailable")
+	expect(t, health.Ready(), false)
+	health.SetReady("db")
+	expect(t, health.Ready(), true)
+}
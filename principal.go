@@ -0,0 +1,83 @@
+package entre
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+)
+
+type principalKey struct{}
+
+type principalSlotKey struct{}
+
+// Principal describes who a request was authenticated as.
+// Every entre auth middleware stores one in the request context on success.
+type Principal struct {
+	// ID uniquely identifies the authenticated user or client.
+	ID string
+	// Name is a human readable name, the same as the ID when there is no better name.
+	Name string
+	// Roles are the roles or scopes granted to the principal.
+	Roles []string
+	// Claims holds any further attributes provided by the auth method, such as token claims.
+	Claims map[string]interface{}
+	// AuthMethod is the auth method the principal was authenticated with, such as "basic".
+	AuthMethod string
+}
+
+// HasRole reports whether or not the principal has been granted the provided role.
+func (p *Principal) HasRole(role string) bool {
+	if p == nil {
+		return false
+	}
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// WithPrincipal provides a copy of the request with the provided principal stored in its context.
+func WithPrincipal(r *http.Request, p *Principal) *http.Request {
+	if slot, ok := r.Context().Value(principalSlotKey{}).(*atomic.Pointer[Principal]); ok {
+		slot.Store(p)
+	}
+	return r.WithContext(context.WithValue(r.Context(), principalKey{}, p))
+}
+
+// PrincipalFrom retrieves the principal the request was authenticated as,
+// nil when it hasn't been authenticated.
+func PrincipalFrom(r *http.Request) *Principal {
+	if r == nil {
+		return nil
+	}
+	if p, ok := r.Context().Value(principalKey{}).(*Principal); ok {
+		return p
+	}
+	// Middleware earlier in the chain than the auth middleware only see the principal
+	// through the slot they asked to be filled in.
+	if slot, ok := r.Context().Value(principalSlotKey{}).(*atomic.Pointer[Principal]); ok {
+		return slot.Load()
+	}
+	return nil
+}
+
+// trackPrincipal provides a copy of the request which picks up the principal
+// once an auth middleware later in the chain has authenticated it. This lets middleware
+// such as the logger and panic recovery find out who the request was authenticated as.
+func trackPrincipal(r *http.Request) *http.Request {
+	if _, ok := r.Context().Value(principalSlotKey{}).(*atomic.Pointer[Principal]); ok {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), principalSlotKey{}, &atomic.Pointer[Principal]{}))
+}
+
+// principalName provides the name of the principal the request was authenticated as,
+// an empty string when it hasn't been authenticated.
+func principalName(r *http.Request) string {
+	if p := PrincipalFrom(r); p != nil {
+		return p.Name
+	}
+	return ""
+}
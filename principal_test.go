@@ -0,0 +1,54 @@
+package entre
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_PrincipalFrom(t *testing.T) {
+	var principal *Principal
+	e := New(NewBasicAuth("user", "password"))
+	e.PushHandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal = PrincipalFrom(r)
+	})
+	req, err := http.NewRequest("GET", "http://localhost:8384/test", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	expect(t, PrincipalFrom(req) == nil, true)
+	req.SetBasicAuth("user", "password")
+	e.ServeHTTP(httptest.NewRecorder(), req)
+	refute(t, principal == nil, true)
+	expect(t, principal.ID, "user")
+	expect(t, principal.Name, "user")
+	expect(t, principal.AuthMethod, "basic")
+	expect(t, principal.HasRole("admin"), false)
+}
+
+func Test_PrincipalLoggedAndReported(t *testing.T) {
+	buf := bytes.NewBufferString("")
+	l := NewLogger()
+	l.LoggerIface = log.New(buf, "|-entre-|", 0)
+	l.LogUser = true
+	reporter := NewMemoryReporter()
+	pr := NewPanicRecovery(false)
+	pr.Logger = log.New(bytes.NewBufferString(""), "|-entre-|", 0)
+	pr.Reporter = reporter
+	e := New(l, pr, NewBasicAuth("user", "password"))
+	e.PushHandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("You have caused a panic")
+	})
+	req, err := http.NewRequest("GET", "http://localhost:8384/test", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	req.SetBasicAuth("user", "password")
+	e.ServeHTTP(httptest.NewRecorder(), req)
+	expect(t, strings.Contains(buf.String(), "Internal Server Error response for user in"), true)
+	expect(t, len(reporter.Reports()), 1)
+	expect(t, reporter.Reports()[0].User, "user")
+}
@@ -1,23 +1,69 @@
 package entre
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"log"
+	"mime"
 	"net/http"
 	"os"
-	"runtime"
 	"runtime/debug"
 
 	"github.com/julienschmidt/httprouter"
 )
 
+type recoveryKey struct{}
+
 // PanicRecovery is the middleware that handles recovery from panics.
+// When the response has already been committed before the panic, the connection
+// is aborted instead of appending to the response.
+// The response for a recovered panic is negotiated on the Accept header and rendered
+// as text, JSON, problem details JSON or HTML, each of which can be replaced through Renderers.
+// Every panic is given an error ID which is both logged and included in the response
+// so the two can be matched up.
 type PanicRecovery struct {
-	Logger           LoggerIface
-	PrintStack       bool
+	Logger LoggerIface
+	// PrintStack enables development mode in which the panic and its
+	// stack trace are included in the response. HTML responses are rendered
+	// as a developer error page with source snippets for each frame and the request details.
+	PrintStack bool
+	// ErrorHandlerFunc is called with the value of every recovered panic.
 	ErrorHandlerFunc func(interface{})
-	StackAll         bool
-	StackSize        int
+	// PanicHandler is called with the request, the response and the details of every
+	// recovered panic. When it returns true it is expected to have written the response itself,
+	// otherwise the negotiated panic response is written. The response's Written state tells
+	// whether the response was committed before the panic, in which case it can't be changed.
+	PanicHandler func(Response, *http.Request, *Panic) bool
+	// StackAll determines whether the logged stack trace includes every goroutine.
+	StackAll bool
+	// StackSize is the initial size of the buffer the logged stack trace is captured in,
+	// the buffer grows as needed so stack traces are never truncated.
+	StackSize int
+	// Storm enables panic storm protection when set, see PanicStorm.
+	Storm *PanicStorm
+	// Reporter is where recovered panics are forwarded to, such as an error tracker.
+	Reporter Reporter
+	// Renderers provides custom renderers for panic responses keyed by media type,
+	// these take precedence over the built-in renderers.
+	Renderers map[string]PanicRenderer
+}
+
+// Panic describes a recovered panic.
+type Panic struct {
+	// Value is the value the handler panicked with.
+	Value interface{}
+	// ErrorID is the ID the panic was logged with.
+	ErrorID string
+	// RequestID is the ID of the request that panicked, if any.
+	RequestID string
+	// Stack is the raw stack trace as logged.
+	Stack []byte
+	// Frames is the stack trace of the panicking goroutine from where the panic occurred,
+	// without the frames of entre's own middleware.
+	Frames []StackFrame
 }
 
 // NewPanicRecovery deals with create a new instance to be used in a middleware stack.
@@ -30,32 +76,166 @@ func NewPanicRecovery(printStack bool) *PanicRecovery {
 	}
 }
 
+// SetOutput points the panic logger at the provided writer, such as a RotatingFile.
+func (pr *PanicRecovery) SetOutput(w io.Writer) {
+	pr.Logger = log.New(w, "|-entre-|", 0)
+}
+
 func (pr *PanicRecovery) ServeHTTP(w http.ResponseWriter, r *http.Request, ps httprouter.Params, next http.HandlerFunc) {
+	resp := responseFor(w)
+	if r != nil {
+		// Make the recovery available to background work started with Go.
+		r = r.WithContext(context.WithValue(r.Context(), recoveryKey{}, pr))
+		// Panics are reported along with who the request was authenticated as.
+		r = trackPrincipal(r)
+	}
+	route := stormRouteKey(r)
+	if pr.Storm != nil && pr.Storm.reject(resp, route) {
+		return
+	}
 	defer func() {
 		if err := recover(); err != nil {
-			if w.Header().Get("Content-Type") == "" {
-				w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-			}
-			w.WriteHeader(http.StatusInternalServerError)
-			stack := make([]byte, pr.StackSize)
-			stack = stack[:runtime.Stack(stack, pr.StackAll)]
-			f := "PANIC: %s\n%s"
-			pr.Logger.Printf(f, err, stack)
-			if pr.PrintStack {
-				fmt.Fprintf(w, f, err, stack)
+			// http.ErrAbortHandler is how handlers deliberately abort a response
+			// so it is left for the server to deal with.
+			if err == http.ErrAbortHandler {
+				panic(err)
 			}
-			if pr.ErrorHandlerFunc != nil {
-				func() {
-					defer func() {
-						if err := recover(); err != nil {
-							pr.Logger.Printf("provided ErrorHandlerFunc %s had a panic and the stack trace is:\n%s", err, debug.Stack())
-							pr.Logger.Printf("%s\n", debug.Stack())
-						}
-					}()
-					pr.ErrorHandlerFunc(err)
-				}()
+			if committed := pr.recovered(resp, r, route, err); committed {
+				// Headers and possibly part of the body have already been sent so
+				// a panic response can't be written without corrupting the response.
+				// Aborting makes the server close the connection or reset the HTTP/2 stream
+				// so the client can tell the response is incomplete.
+				panic(http.ErrAbortHandler)
 			}
 		}
 	}()
-	next(w, r)
+	next(resp, r)
+}
+
+// recovered logs a recovered panic, hands it to the configured callbacks
+// and writes the panic response unless the PanicHandler already dealt with it.
+// It reports whether the response had already been committed when the panic occurred,
+// in which case no panic response is written.
+func (pr *PanicRecovery) recovered(resp Response, r *http.Request, route string, err interface{}) (committed bool) {
+	committed = resp.Written()
+	note := ""
+	if committed {
+		note = ", response already committed so the connection will be aborted"
+	}
+	p := pr.capture(r, route, err, "PANIC", note)
+	if !pr.callPanicHandler(resp, r, p) && !resp.Written() {
+		details := &PanicDetails{
+			Status:    http.StatusInternalServerError,
+			Title:     http.StatusText(http.StatusInternalServerError),
+			ErrorID:   p.ErrorID,
+			RequestID: p.RequestID,
+		}
+		if pr.PrintStack {
+			details.Panic = fmt.Sprint(err)
+			details.Stack = formatStack(p.Frames)
+			details.Frames = p.Frames
+		}
+		pr.render(resp, r, details)
+	}
+	pr.notify(r, p)
+	return committed
+}
+
+// capture captures the stack of a recovered panic and logs it,
+// recording it against the route for panic storm protection.
+// This must be called from the deferred function that recovered the panic.
+func (pr *PanicRecovery) capture(r *http.Request, route string, err interface{}, label string, note string) *Panic {
+	stack := rawStack(pr.StackSize, pr.StackAll)
+	p := &Panic{
+		Value:     err,
+		ErrorID:   newErrorID(),
+		RequestID: RequestIDFrom(r),
+		Stack:     stack,
+		Frames:    captureStack(),
+	}
+	if pr.Storm != nil {
+		if repeats := pr.Storm.record(route, p.Frames); repeats > 0 {
+			logf(pr.Logger, SeverityCritical, "%s%s: %s (error ID %s%s, same stack repeated %d times within %v, stack omitted)",
+				requestIDLogPrefix(r), label, err, p.ErrorID, note, repeats, pr.Storm.Window)
+			return p
+		}
+	}
+	logf(pr.Logger, SeverityCritical, "%s%s: %s (error ID %s%s)\n%s", requestIDLogPrefix(r), label, err, p.ErrorID, note, stack)
+	return p
+}
+
+// notify forwards a recovered panic to the Reporter and the ErrorHandlerFunc.
+func (pr *PanicRecovery) notify(r *http.Request, p *Panic) {
+	report := newErrorReport("panic", p.ErrorID, fmt.Sprint(p.Value), r)
+	report.Frames = p.Frames
+	safeReport(pr.Reporter, pr.Logger, report)
+	if pr.ErrorHandlerFunc != nil {
+		func() {
+			defer func() {
+				if err := recover(); err != nil {
+					logf(pr.Logger, SeverityError, "provided ErrorHandlerFunc %s had a panic and the stack trace is:\n%s", err, debug.Stack())
+					logf(pr.Logger, SeverityError, "%s\n", debug.Stack())
+				}
+			}()
+			pr.ErrorHandlerFunc(p.Value)
+		}()
+	}
+}
+
+// callPanicHandler calls the PanicHandler when one is set, reporting whether
+// or not it dealt with the response. A PanicHandler which panics itself is treated as
+// not having dealt with the response unless it has already written to it.
+func (pr *PanicRecovery) callPanicHandler(resp Response, r *http.Request, p *Panic) (handled bool) {
+	if pr.PanicHandler == nil {
+		return false
+	}
+	defer func() {
+		if err := recover(); err != nil {
+			logf(pr.Logger, SeverityError, "%sprovided PanicHandler %s had a panic and the stack trace is:\n%s", requestIDLogPrefix(r), err, debug.Stack())
+			handled = resp.Written()
+		}
+	}()
+	return pr.PanicHandler(resp, r, p)
+}
+
+// render writes the panic response in the media type negotiated with the client.
+// Without an Accept header a Content-Type already set by the handler is honoured
+// when it can be rendered, otherwise plain text is used.
+func (pr *PanicRecovery) render(w http.ResponseWriter, r *http.Request, details *PanicDetails) {
+	offers := panicMediaTypes(pr.Renderers)
+	mediaType := ""
+	accept := ""
+	if r != nil {
+		accept = r.Header.Get("Accept")
+	}
+	if accept != "" {
+		mediaType = negotiate(accept, offers)
+	} else if preset, _, err := mime.ParseMediaType(w.Header().Get("Content-Type")); err == nil && containsFold(offers, preset) {
+		mediaType = preset
+	}
+	if mediaType == "" {
+		mediaType = mediaTypeText
+	}
+	renderer, ok := pr.Renderers[mediaType]
+	if !ok && pr.PrintStack && mediaType == mediaTypeHTML {
+		renderer, ok = developerErrorPage(r), true
+	}
+	if !ok {
+		renderer = defaultPanicRenderers()[mediaType]
+	}
+	w.Header().Set("Content-Type", contentTypeFor(mediaType))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(details.Status)
+	if err := renderer(w, details); err != nil {
+		logf(pr.Logger, SeverityError, "%sunable to render the %s response for error ID %s: %s", requestIDLogPrefix(r), mediaType, details.ErrorID, err)
+	}
+}
+
+// newErrorID generates an ID to correlate a panic response with its log entry.
+func newErrorID() string {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b[:])
 }
@@ -0,0 +1,421 @@
+package entre
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// ErrorReport describes a panic or error forwarded to a Reporter.
+type ErrorReport struct {
+	// ID is the error ID the panic or error was logged and responded with.
+	ID string `json:"id"`
+	// Kind is either "panic" or "error".
+	Kind      string    `json:"kind"`
+	Time      time.Time `json:"time"`
+	Message   string    `json:"message"`
+	RequestID string    `json:"request_id,omitempty"`
+	Method    string    `json:"method,omitempty"`
+	URL       string    `json:"url,omitempty"`
+	Route     string    `json:"route,omitempty"`
+	// User is the name of the principal the request was authenticated as.
+	User   string       `json:"user,omitempty"`
+	Frames []StackFrame `json:"frames,omitempty"`
+}
+
+// newErrorReport creates a report for the provided request.
+func newErrorReport(kind string, id string, message string, r *http.Request) *ErrorReport {
+	report := &ErrorReport{
+		ID:      id,
+		Kind:    kind,
+		Time:    time.Now().UTC(),
+		Message: message,
+	}
+	if r != nil {
+		report.RequestID = RequestIDFrom(r)
+		report.Method = r.Method
+		report.URL = redactedURL(r)
+		report.Route = RouteFrom(r)
+		report.User = principalName(r)
+	}
+	return report
+}
+
+// redactedURL returns the request URL with sensitive query parameters,
+// such as API keys, redacted using the default body logging rules.
+func redactedURL(r *http.Request) string {
+	if r.URL.RawQuery == "" {
+		return r.URL.Path
+	}
+	return r.URL.Path + "?" + NewBodyLogging().formatQuery(r.URL.RawQuery)
+}
+
+// Reporter forwards panics and errors to something like an error tracker.
+// Reporters are called while requests are being served so they shouldn't block.
+type Reporter interface {
+	Report(*ErrorReport) error
+}
+
+// ReporterFunc allows a plain function to be used as a Reporter.
+type ReporterFunc func(*ErrorReport) error
+
+// Report calls the underlying function.
+func (f ReporterFunc) Report(report *ErrorReport) error {
+	return f(report)
+}
+
+// MultiReporter provides a Reporter which forwards reports to every one of the provided reporters.
+func MultiReporter(reporters ...Reporter) Reporter {
+	return ReporterFunc(func(report *ErrorReport) error {
+		var firstErr error
+		for _, reporter := range reporters {
+			if err := reporter.Report(report); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+		return firstErr
+	})
+}
+
+// safeReport hands a report to the reporter, logging rather than propagating
+// any failure or panic in the reporter.
+func safeReport(reporter Reporter, logger LoggerIface, report *ErrorReport) {
+	if reporter == nil {
+		return
+	}
+	defer func() {
+		if err := recover(); err != nil {
+			logf(logger, SeverityError, "provided Reporter had a panic while reporting error ID %s: %s", report.ID, err)
+		}
+	}()
+	if err := reporter.Report(report); err != nil {
+		logf(logger, SeverityError, "provided Reporter failed to report error ID %s: %s", report.ID, err)
+	}
+}
+
+// StatusError is implemented by errors which carry the status code
+// of the response that should be written for them.
+type StatusError interface {
+	error
+	StatusCode() int
+}
+
+// ErrHandlerFunc is a handler which returns errors rather than writing error responses itself.
+type ErrHandlerFunc func(http.ResponseWriter, *http.Request) error
+
+// UseErrHandlerFunc wraps an error returning handler so it can be used as part of the entre
+// middleware chain. When the handler returns an error a response is written for it,
+// unless the handler already wrote one, with the status code of a StatusError or 500 otherwise.
+// Every error is logged to the logger along with the error ID sent in the X-Error-ID header,
+// standard output is logged to when the logger is nil. Errors resulting in server error responses
+// are also forwarded to the reporter, which can be nil.
+func UseErrHandlerFunc(h ErrHandlerFunc, reporter Reporter, logger LoggerIface) Handler {
+	if logger == nil {
+		logger = log.New(os.Stdout, "|-entre-|", 0)
+	}
+	return HandlerFunc(func(w http.ResponseWriter, r *http.Request, ps httprouter.Params, next http.HandlerFunc) {
+		resp := responseFor(w)
+		if err := h(resp, r); err != nil {
+			status := http.StatusInternalServerError
+			if statusErr, ok := err.(StatusError); ok {
+				status = statusErr.StatusCode()
+			}
+			id := newErrorID()
+			severity := SeverityWarning
+			if status >= http.StatusInternalServerError {
+				severity = SeverityError
+			}
+			logf(logger, severity, "%sHandler returned an error with status %d: %s (error ID %s)", requestIDLogPrefix(r), status, err, id)
+			if status >= http.StatusInternalServerError {
+				safeReport(reporter, logger, newErrorReport("error", id, err.Error(), r))
+			}
+			if !resp.Written() {
+				resp.Header().Set("X-Error-ID", id)
+				http.Error(resp, http.StatusText(status), status)
+			}
+		}
+		next(resp, r)
+	})
+}
+
+// MemoryReporter keeps reports in memory, this is intended for tests.
+type MemoryReporter struct {
+	mu      sync.Mutex
+	reports []*ErrorReport
+}
+
+// NewMemoryReporter creates a new in-memory reporter.
+func NewMemoryReporter() *MemoryReporter {
+	return &MemoryReporter{}
+}
+
+// Report stores the report.
+func (m *MemoryReporter) Report(report *ErrorReport) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.reports = append(m.reports, report)
+	return nil
+}
+
+// Reports provides the reports received so far.
+func (m *MemoryReporter) Reports() []*ErrorReport {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]*ErrorReport{}, m.reports...)
+}
+
+// Reset discards the reports received so far.
+func (m *MemoryReporter) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.reports = nil
+}
+
+// FileReporter writes reports as newline delimited JSON to a file.
+type FileReporter struct {
+	mu   sync.Mutex
+	w    io.Writer
+	file *os.File
+}
+
+// NewFileReporter opens (or creates) the provided file to append reports to.
+func NewFileReporter(filename string) (*FileReporter, error) {
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileReporter{w: file, file: file}, nil
+}
+
+// NewWriterReporter creates a reporter which writes newline delimited JSON reports
+// to the provided writer, such as a RotatingFile.
+func NewWriterReporter(w io.Writer) *FileReporter {
+	return &FileReporter{w: w}
+}
+
+// Report writes the report as a single line of JSON.
+func (f *FileReporter) Report(report *ErrorReport) error {
+	line, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, err = f.w.Write(append(line, '\n'))
+	return err
+}
+
+// Close closes the file opened by NewFileReporter.
+func (f *FileReporter) Close() error {
+	if f.file == nil {
+		return nil
+	}
+	return f.file.Close()
+}
+
+// WebhookReporter posts reports as JSON arrays to a webhook.
+// Reports are queued and sent in batches from a background goroutine so reporting
+// never blocks requests, when the bounded queue is full reports are dropped.
+// Failed deliveries are retried with exponential backoff.
+type WebhookReporter struct {
+	// URL is the webhook reports are posted to.
+	URL string
+	// Client is the HTTP client used to post reports.
+	Client *http.Client
+	// Header holds extra headers sent with every request, such as for authentication.
+	Header http.Header
+	// BatchSize is the maximum number of reports sent in one request.
+	BatchSize int
+	// FlushInterval is how long reports are held for while waiting for a batch to fill up.
+	FlushInterval time.Duration
+	// QueueSize is the maximum number of reports waiting to be sent.
+	QueueSize int
+	// MaxRetries is the number of times a failed delivery is retried.
+	MaxRetries int
+	// RetryBackoff is the wait before the first retry, this doubles with every retry.
+	RetryBackoff time.Duration
+	// ErrorLog is where failed deliveries are logged.
+	ErrorLog LoggerIface
+
+	startOnce sync.Once
+	queue     chan *ErrorReport
+	done      chan struct{}
+	stopped   chan struct{}
+	mu        sync.Mutex
+	closed    bool
+	dropped   int
+}
+
+// NewWebhookReporter creates a reporter which posts reports to the provided webhook URL.
+// The reporter's settings can be changed up until the first report is made.
+func NewWebhookReporter(url string) *WebhookReporter {
+	return &WebhookReporter{
+		URL:           url,
+		Client:        &http.Client{Timeout: 10 * time.Second},
+		Header:        http.Header{},
+		BatchSize:     20,
+		FlushInterval: 5 * time.Second,
+		QueueSize:     1000,
+		MaxRetries:    3,
+		RetryBackoff:  500 * time.Millisecond,
+		ErrorLog:      log.New(os.Stdout, "|-entre-|", 0),
+	}
+}
+
+// Report queues the report to be sent, an error is returned when the queue is full
+// or the reporter has been closed.
+func (wr *WebhookReporter) Report(report *ErrorReport) error {
+	wr.start()
+	// The lock is held while queueing so a report can't be queued after Close
+	// has stopped the background goroutine, which would leave it unsent.
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+	if wr.closed {
+		return fmt.Errorf("the webhook reporter has been closed")
+	}
+	select {
+	case wr.queue <- report:
+		return nil
+	default:
+		wr.dropped++
+		return fmt.Errorf("the webhook reporter queue is full, report %s was dropped", report.ID)
+	}
+}
+
+// Dropped provides the number of reports dropped because the queue was full.
+func (wr *WebhookReporter) Dropped() int {
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+	return wr.dropped
+}
+
+// Close sends any queued reports and stops the background goroutine.
+func (wr *WebhookReporter) Close() error {
+	wr.start()
+	wr.mu.Lock()
+	if !wr.closed {
+		wr.closed = true
+		close(wr.done)
+	}
+	wr.mu.Unlock()
+	<-wr.stopped
+	return nil
+}
+
+// start starts the background goroutine, filling in the defaults of NewWebhookReporter
+// for any settings left unset so a reporter created as a struct literal works too.
+func (wr *WebhookReporter) start() {
+	wr.startOnce.Do(func() {
+		if wr.Client == nil {
+			wr.Client = &http.Client{Timeout: 10 * time.Second}
+		}
+		if wr.BatchSize <= 0 {
+			wr.BatchSize = 20
+		}
+		if wr.FlushInterval <= 0 {
+			wr.FlushInterval = 5 * time.Second
+		}
+		if wr.QueueSize <= 0 {
+			wr.QueueSize = 1000
+		}
+		if wr.ErrorLog == nil {
+			wr.ErrorLog = log.New(os.Stdout, "|-entre-|", 0)
+		}
+		wr.queue = make(chan *ErrorReport, wr.QueueSize)
+		wr.done = make(chan struct{})
+		wr.stopped = make(chan struct{})
+		go wr.run()
+	})
+}
+
+func (wr *WebhookReporter) run() {
+	defer close(wr.stopped)
+	batch := []*ErrorReport{}
+	timer := time.NewTimer(wr.FlushInterval)
+	defer timer.Stop()
+	flush := func() {
+		if len(batch) > 0 {
+			wr.send(batch)
+			batch = []*ErrorReport{}
+		}
+	}
+	for {
+		select {
+		case report := <-wr.queue:
+			batch = append(batch, report)
+			if len(batch) >= wr.BatchSize {
+				flush()
+			}
+		case <-timer.C:
+			flush()
+			timer.Reset(wr.FlushInterval)
+		case <-wr.done:
+			for {
+				select {
+				case report := <-wr.queue:
+					batch = append(batch, report)
+					if len(batch) >= wr.BatchSize {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// send posts a batch of reports, retrying failed deliveries.
+func (wr *WebhookReporter) send(batch []*ErrorReport) {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		logf(wr.ErrorLog, SeverityError, "unable to encode %d error reports: %s", len(batch), err)
+		return
+	}
+	backoff := wr.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		var retry bool
+		retry, err = wr.post(body)
+		if err == nil {
+			return
+		}
+		if !retry || attempt >= wr.MaxRetries {
+			break
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+	logf(wr.ErrorLog, SeverityError, "unable to deliver %d error reports to the webhook: %s", len(batch), err)
+}
+
+// post makes a single delivery attempt, reporting whether a failed attempt is worth retrying.
+func (wr *WebhookReporter) post(body []byte) (bool, error) {
+	req, err := http.NewRequest("POST", wr.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	for name, values := range wr.Header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := wr.Client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode >= 300 {
+		retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+		return retry, fmt.Errorf("the webhook responded with %s", resp.Status)
+	}
+	return false, nil
+}
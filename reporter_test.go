@@ -0,0 +1,251 @@
+package entre
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_PanicRecoveryReporter(t *testing.T) {
+	reporter := NewMemoryReporter()
+	r := NewPanicRecovery(false)
+	r.Logger = log.New(bytes.NewBufferString(""), "|-entre-|", 0)
+	r.Reporter = reporter
+	e := New(NewRequestID(), UseRoute("/test"), r)
+	e.PushHandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		panic("You have caused a panic")
+	})
+	req, err := http.NewRequest("GET", "http://localhost:8384/test", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	req.Header.Set("Accept", "application/json")
+	recorder := httptest.NewRecorder()
+	e.ServeHTTP(recorder, req)
+	reports := reporter.Reports()
+	expect(t, len(reports), 1)
+	expect(t, reports[0].Kind, "panic")
+	expect(t, reports[0].Message, "You have caused a panic")
+	expect(t, reports[0].Route, "/test")
+	expect(t, reports[0].RequestID, recorder.Header().Get("X-Request-ID"))
+	refute(t, len(reports[0].Frames), 0)
+	var body map[string]interface{}
+	json.Unmarshal(recorder.Body.Bytes(), &body)
+	expect(t, body["error_id"], reports[0].ID)
+}
+
+func Test_PanicRecoveryReporterRedactsQuery(t *testing.T) {
+	reporter := NewMemoryReporter()
+	r := NewPanicRecovery(true)
+	r.Logger = log.New(bytes.NewBufferString(""), "|-entre-|", 0)
+	r.Reporter = reporter
+	e := New(r)
+	e.PushHandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		panic("You have caused a panic")
+	})
+	// The key is built at runtime as the developer page shows the source around the panic.
+	key := strings.Repeat("k", 24)
+	req, err := http.NewRequest("GET", "http://localhost:8384/test?api_key="+key+"&page=2", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	req.Header.Set("Accept", "text/html")
+	recorder := httptest.NewRecorder()
+	e.ServeHTTP(recorder, req)
+	reports := reporter.Reports()
+	expect(t, len(reports), 1)
+	expect(t, reports[0].URL, "/test?api_key=[REDACTED]&page=2")
+	// The developer page leaves the key out too.
+	expect(t, strings.Contains(recorder.Body.String(), key), false)
+	expect(t, strings.Contains(recorder.Body.String(), "page=2"), true)
+}
+
+type teapotError struct{}
+
+func (teapotError) Error() string   { return "I'm a teapot" }
+func (teapotError) StatusCode() int { return http.StatusTeapot }
+
+func Test_UseErrHandlerFunc(t *testing.T) {
+	reporter := NewMemoryReporter()
+	buf := bytes.NewBufferString("")
+	var handlerErr error
+	e := New(UseErrHandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		return handlerErr
+	}, reporter, log.New(buf, "|-entre-|", 0)))
+	req, err := http.NewRequest("GET", "http://localhost:8384/test", nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	handlerErr = errors.New("the database is down")
+	recorder := httptest.NewRecorder()
+	e.ServeHTTP(recorder, req)
+	expect(t, recorder.Code, http.StatusInternalServerError)
+	expect(t, len(reporter.Reports()), 1)
+	expect(t, reporter.Reports()[0].Kind, "error")
+	expect(t, reporter.Reports()[0].Message, "the database is down")
+	expect(t, reporter.Reports()[0].ID, recorder.Header().Get("X-Error-ID"))
+	// The error is logged with the ID the client was given.
+	expect(t, strings.Contains(buf.String(), "the database is down (error ID "+recorder.Header().Get("X-Error-ID")+")"), true)
+
+	// Client errors aren't reported.
+	reporter.Reset()
+	handlerErr = teapotError{}
+	recorder = httptest.NewRecorder()
+	e.ServeHTTP(recorder, req)
+	expect(t, recorder.Code, http.StatusTeapot)
+	expect(t, len(reporter.Reports()), 0)
+
+	handlerErr = nil
+	recorder = httptest.NewRecorder()
+	e.ServeHTTP(recorder, req)
+	expect(t, recorder.Code, http.StatusOK)
+}
+
+func Test_FileReporter(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "errors.ndjson")
+	reporter, err := NewFileReporter(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reporter.Report(&ErrorReport{ID: "one", Kind: "panic"})
+	reporter.Report(&ErrorReport{ID: "two", Kind: "error"})
+	reporter.Close()
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	ids := []string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var report ErrorReport
+		if err := json.Unmarshal(scanner.Bytes(), &report); err != nil {
+			t.Error(err)
+		}
+		ids = append(ids, report.ID)
+	}
+	expect(t, len(ids), 2)
+	expect(t, ids[1], "two")
+}
+
+type webhookTracker struct {
+	mu       sync.Mutex
+	batches  [][]ErrorReport
+	failures int
+}
+
+func (wt *webhookTracker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	wt.mu.Lock()
+	defer wt.mu.Unlock()
+	if wt.failures > 0 {
+		wt.failures--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	var batch []ErrorReport
+	if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	wt.batches = append(wt.batches, batch)
+}
+
+func Test_WebhookReporter(t *testing.T) {
+	tracker := &webhookTracker{failures: 1}
+	server := httptest.NewServer(tracker)
+	defer server.Close()
+	reporter := NewWebhookReporter(server.URL)
+	reporter.BatchSize = 2
+	reporter.FlushInterval = time.Hour
+	reporter.RetryBackoff = time.Millisecond
+	reporter.ErrorLog = log.New(bytes.NewBufferString(""), "|-entre-|", 0)
+	for _, id := range []string{"one", "two", "three"} {
+		if err := reporter.Report(&ErrorReport{ID: id}); err != nil {
+			t.Error(err)
+		}
+	}
+	// Closing sends the remaining partial batch.
+	reporter.Close()
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+	expect(t, len(tracker.batches), 2)
+	expect(t, len(tracker.batches[0]), 2)
+	expect(t, tracker.batches[0][0].ID, "one")
+	expect(t, tracker.batches[1][0].ID, "three")
+	refute(t, reporter.Report(&ErrorReport{ID: "four"}), nil)
+}
+
+func Test_WebhookReporterStructLiteral(t *testing.T) {
+	tracker := &webhookTracker{}
+	server := httptest.NewServer(tracker)
+	defer server.Close()
+	// Settings left unset get the defaults rather than crashing the background goroutine.
+	reporter := &WebhookReporter{URL: server.URL}
+	expect(t, reporter.Report(&ErrorReport{ID: "one"}), nil)
+	reporter.Close()
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+	expect(t, len(tracker.batches), 1)
+	expect(t, tracker.batches[0][0].ID, "one")
+}
+
+func Test_WebhookReporterQueueFull(t *testing.T) {
+	block := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-block
+	}))
+	defer server.Close()
+	reporter := NewWebhookReporter(server.URL)
+	reporter.BatchSize = 1
+	reporter.QueueSize = 1
+	reporter.ErrorLog = log.New(bytes.NewBufferString(""), "|-entre-|", 0)
+	reporter.Report(&ErrorReport{ID: "one"})
+	// Wait for the first report to be taken off the queue and sent.
+	time.Sleep(50 * time.Millisecond)
+	reporter.Report(&ErrorReport{ID: "two"})
+	refute(t, reporter.Report(&ErrorReport{ID: "three"}), nil)
+	expect(t, reporter.Dropped(), 1)
+	close(block)
+	reporter.Close()
+}
+
+func Test_WebhookReporterCloseRace(t *testing.T) {
+	tracker := &webhookTracker{}
+	server := httptest.NewServer(tracker)
+	defer server.Close()
+	reporter := NewWebhookReporter(server.URL)
+	reporter.FlushInterval = time.Hour
+	var accepted int32
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if reporter.Report(&ErrorReport{ID: "race"}) == nil {
+				atomic.AddInt32(&accepted, 1)
+			}
+		}()
+	}
+	reporter.Close()
+	wg.Wait()
+	// Every report accepted before or during Close is sent.
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+	sent := 0
+	for _, batch := range tracker.batches {
+		sent += len(batch)
+	}
+	expect(t, int32(sent), atomic.LoadInt32(&accepted))
+}
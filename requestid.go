@@ -0,0 +1,156 @@
+package entre
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// DefaultRequestIDHeader is the header used to read and echo request IDs
+// when no other header has been configured.
+const DefaultRequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength caps the size of incoming request IDs we are willing to trust.
+const maxRequestIDLength = 128
+
+type requestIDKey struct{}
+
+// RequestID is the middleware that assigns each request a correlation ID.
+// An ID provided by the client in the configured header is re-used as long as
+// it is sane, otherwise a new one is generated.
+// The ID is echoed on the response and stored in the request context
+// where it can be retrieved with RequestIDFrom.
+type RequestID struct {
+	// Header is the name of the header the request ID is read from and written to.
+	Header string
+	// Generator produces new request IDs, this defaults to random UUIDv4 values.
+	Generator func() string
+	// TrustIncoming determines whether or not IDs provided by the client are used.
+	TrustIncoming bool
+}
+
+// NewRequestID creates a new request ID middleware instance which uses
+// the X-Request-ID header and generates UUIDv4 request IDs.
+func NewRequestID() *RequestID {
+	return &RequestID{
+		Header:        DefaultRequestIDHeader,
+		Generator:     NewUUID,
+		TrustIncoming: true,
+	}
+}
+
+func (rid *RequestID) ServeHTTP(w http.ResponseWriter, r *http.Request, ps httprouter.Params, next http.HandlerFunc) {
+	header := rid.header()
+	id := ""
+	if rid.TrustIncoming {
+		id = r.Header.Get(header)
+		if !validRequestID(id) {
+			id = ""
+		}
+	}
+	if id == "" {
+		if rid.Generator != nil {
+			id = rid.Generator()
+		} else {
+			id = NewUUID()
+		}
+	}
+	w.Header().Set(header, id)
+	next(w, WithRequestID(r, id))
+}
+
+func (rid *RequestID) header() string {
+	if rid.Header == "" {
+		return DefaultRequestIDHeader
+	}
+	return rid.Header
+}
+
+// WithRequestID provides a shallow copy of the given request
+// with the request ID stored in its context.
+func WithRequestID(r *http.Request, id string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id))
+}
+
+// RequestIDFrom retrieves the request ID for the provided request
+// or an empty string when no ID has been assigned.
+func RequestIDFrom(r *http.Request) string {
+	if r == nil {
+		return ""
+	}
+	return RequestIDFromContext(r.Context())
+}
+
+// RequestIDFromContext retrieves the request ID stored in the provided context.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// RequestIDTransport is a http.RoundTripper which propagates the request ID
+// found in an outbound request's context to the downstream service.
+// Outbound requests should be created with the incoming request's context
+// for the ID to be carried over.
+type RequestIDTransport struct {
+	// Header is the name of the header the request ID is sent in.
+	Header string
+	// Base is the underlying transport, http.DefaultTransport is used when nil.
+	Base http.RoundTripper
+}
+
+// RoundTrip sets the request ID header on outbound requests which don't already have one.
+func (t *RequestIDTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	header := t.Header
+	if header == "" {
+		header = DefaultRequestIDHeader
+	}
+	id := RequestIDFromContext(req.Context())
+	if id == "" || req.Header.Get(header) != "" {
+		return base.RoundTrip(req)
+	}
+	// A RoundTripper must not modify the request it was given.
+	out := req.Clone(req.Context())
+	out.Header.Set(header, id)
+	return base.RoundTrip(out)
+}
+
+// NewUUID generates a random (version 4) UUID.
+func NewUUID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// validRequestID ensures incoming IDs are of a reasonable size and only
+// made up of visible ASCII characters so they can't be used to inject content into logs.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// requestIDLogPrefix produces the prefix used by the bundled middleware
+// to tag log entries with the request ID.
+func requestIDLogPrefix(r *http.Request) string {
+	if id := RequestIDFrom(r); id != "" {
+		return "[" + id + "] "
+	}
+	return ""
+}
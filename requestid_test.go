@@ -0,0 +1,98 @@
+package entre
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_RequestIDGenerated(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	var ctxID string
+	e := New(NewRequestID())
+	e.PushHandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctxID = RequestIDFrom(r)
+	})
+	req, err := http.NewRequest("GET", "http://localhost:8384/test", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	e.ServeHTTP(recorder, req)
+	expect(t, len(ctxID), 36)
+	expect(t, recorder.Header().Get("X-Request-ID"), ctxID)
+}
+
+func Test_RequestIDIncoming(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	var ctxID string
+	rid := NewRequestID()
+	rid.Header = "X-Correlation-ID"
+	e := New(rid)
+	e.PushHandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctxID = RequestIDFrom(r)
+	})
+	req, err := http.NewRequest("GET", "http://localhost:8384/test", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	req.Header.Set("X-Correlation-ID", "abc-123")
+	e.ServeHTTP(recorder, req)
+	expect(t, ctxID, "abc-123")
+	expect(t, recorder.Header().Get("X-Correlation-ID"), "abc-123")
+
+	// IDs which could be used to inject content in to logs must be replaced.
+	recorder = httptest.NewRecorder()
+	req.Header.Set("X-Correlation-ID", "abc\n123")
+	e.ServeHTTP(recorder, req)
+	refute(t, ctxID, "abc\n123")
+	expect(t, len(ctxID), 36)
+}
+
+func Test_RequestIDInLogs(t *testing.T) {
+	logBuf := bytes.NewBufferString("")
+	recoveryBuf := bytes.NewBufferString("")
+	l := NewLogger()
+	l.LoggerIface = log.New(logBuf, "|-entre-|", 0)
+	pr := NewPanicRecovery(false)
+	pr.Logger = log.New(recoveryBuf, "|-entre-|", 0)
+	e := New(NewRequestID(), l, pr)
+	e.PushHandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("You have caused a panic")
+	})
+	req, err := http.NewRequest("GET", "http://localhost:8384/test", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	req.Header.Set("X-Request-ID", "my-request-id")
+	e.ServeHTTP(httptest.NewRecorder(), req)
+	expect(t, strings.Count(logBuf.String(), "[my-request-id]"), 2)
+	expect(t, strings.Contains(recoveryBuf.String(), "[my-request-id] PANIC"), true)
+}
+
+func Test_RequestIDTransport(t *testing.T) {
+	var received string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Get("X-Request-ID")
+	}))
+	defer server.Close()
+	client := &http.Client{Transport: &RequestIDTransport{}}
+	in, err := http.NewRequest("GET", "http://localhost:8384/test", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	in = WithRequestID(in, "propagated-id")
+	out, err := http.NewRequestWithContext(in.Context(), "GET", server.URL, nil)
+	if err != nil {
+		t.Error(err)
+	}
+	resp, err := client.Do(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	expect(t, received, "propagated-id")
+	expect(t, out.Header.Get("X-Request-ID"), "")
+}
@@ -36,6 +36,15 @@ func NewResponse(w http.ResponseWriter) Response {
 	return resp
 }
 
+// responseFor re-uses the provided writer when it is already a Response
+// so middleware further up the stack share the same response state.
+func responseFor(w http.ResponseWriter) Response {
+	if resp, ok := w.(Response); ok {
+		return resp
+	}
+	return NewResponse(w)
+}
+
 type response struct {
 	http.ResponseWriter
 	status int
@@ -0,0 +1,298 @@
+package entre
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// backupTimeFormat is used in the names of rotated files,
+// it sorts lexically in the same order as chronologically.
+const backupTimeFormat = "2006-01-02T15-04-05.000"
+
+// RotatingFile is an io.Writer which writes to a log file that is rotated
+// once it grows beyond a maximum size or has been written to for longer than a maximum age.
+// Rotated files are kept as backups alongside the log file, optionally gzipped.
+// It can be used as the output for both the logging and panic recovery middleware.
+//
+//	f, err := entre.NewRotatingFile("/var/log/app/access.log")
+//	logger := entre.NewLogger()
+//	logger.SetOutput(f)
+type RotatingFile struct {
+	// Filename is the path of the log file.
+	Filename string
+	// MaxSize is the size in bytes the log file can reach before it gets rotated,
+	// size based rotation is disabled when this is 0.
+	MaxSize int64
+	// MaxAge is how long a log file is written to before it gets rotated,
+	// age based rotation is disabled when this is 0.
+	MaxAge time.Duration
+	// MaxBackups is the number of rotated files to keep, all backups are kept when this is 0.
+	MaxBackups int
+	// Compress determines whether or not rotated files are gzipped.
+	Compress bool
+
+	mu       sync.Mutex
+	file     *os.File
+	size     int64
+	openedAt time.Time
+	closed   bool
+	millMu   sync.Mutex
+	milling  sync.WaitGroup
+	signals  chan os.Signal
+}
+
+// NewRotatingFile opens (or creates) the provided log file for appending.
+// The file is rotated once it reaches 100MB and 7 backups are kept by default.
+func NewRotatingFile(filename string) (*RotatingFile, error) {
+	f := &RotatingFile{
+		Filename:   filename,
+		MaxSize:    100 * 1024 * 1024,
+		MaxBackups: 7,
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Write writes to the log file, rotating it first when the write
+// would take it beyond MaxSize or the file is older than MaxAge.
+// Writing after Close fails with os.ErrClosed.
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+	if f.file == nil {
+		if err := f.open(); err != nil {
+			return 0, err
+		}
+	}
+	if f.shouldRotate(int64(len(p))) {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// Rotate rotates the log file regardless of its size and age.
+func (f *RotatingFile) Rotate() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return os.ErrClosed
+	}
+	return f.rotate()
+}
+
+// Reopen closes and re-opens the log file. This is for compatibility with external
+// tools like logrotate which move the log file out of the way and then signal the process.
+func (f *RotatingFile) Reopen() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return os.ErrClosed
+	}
+	if err := f.close(); err != nil {
+		return err
+	}
+	return f.open()
+}
+
+// ReopenOnSignal re-opens the log file every time the process receives
+// one of the provided signals, SIGHUP is used when none are provided.
+func (f *RotatingFile) ReopenOnSignal(sig ...os.Signal) {
+	if len(sig) == 0 {
+		sig = []os.Signal{syscall.SIGHUP}
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.signals != nil {
+		signal.Stop(f.signals)
+		close(f.signals)
+	}
+	signals := make(chan os.Signal, 1)
+	f.signals = signals
+	signal.Notify(signals, sig...)
+	go func() {
+		for range signals {
+			f.Reopen()
+		}
+	}()
+}
+
+// Close stops listening for signals, closes the log file and waits for
+// any rotated files to finish being compressed.
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	if f.signals != nil {
+		signal.Stop(f.signals)
+		close(f.signals)
+		f.signals = nil
+	}
+	f.closed = true
+	err := f.close()
+	f.mu.Unlock()
+	f.milling.Wait()
+	return err
+}
+
+func (f *RotatingFile) shouldRotate(writeSize int64) bool {
+	if f.MaxSize > 0 && f.size > 0 && f.size+writeSize > f.MaxSize {
+		return true
+	}
+	return f.MaxAge > 0 && time.Since(f.openedAt) > f.MaxAge
+}
+
+func (f *RotatingFile) open() error {
+	if err := os.MkdirAll(filepath.Dir(f.Filename), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(f.Filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	f.openedAt = time.Now()
+	return nil
+}
+
+func (f *RotatingFile) close() error {
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+func (f *RotatingFile) rotate() error {
+	if err := f.close(); err != nil {
+		return err
+	}
+	backup := f.backupName(time.Now())
+	if err := os.Rename(f.Filename, backup); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := f.open(); err != nil {
+		return err
+	}
+	f.milling.Add(1)
+	go func() {
+		defer f.milling.Done()
+		f.mill(backup)
+	}()
+	return nil
+}
+
+// mill compresses a newly rotated file and removes backups
+// beyond the configured limit.
+func (f *RotatingFile) mill(backup string) {
+	f.millMu.Lock()
+	defer f.millMu.Unlock()
+	if f.Compress {
+		compressFile(backup)
+	}
+	if f.MaxBackups <= 0 {
+		return
+	}
+	backups, err := f.backups()
+	if err != nil || len(backups) <= f.MaxBackups {
+		return
+	}
+	for _, old := range backups[:len(backups)-f.MaxBackups] {
+		os.Remove(old)
+	}
+}
+
+// backupName provides a name for a rotated file which isn't already taken
+// by an earlier backup.
+func (f *RotatingFile) backupName(t time.Time) string {
+	ext := filepath.Ext(f.Filename)
+	base := strings.TrimSuffix(f.Filename, ext)
+	for {
+		name := fmt.Sprintf("%s-%s%s", base, t.Format(backupTimeFormat), ext)
+		if !fileExists(name) && !fileExists(name+".gz") {
+			return name
+		}
+		t = t.Add(time.Millisecond)
+	}
+}
+
+func fileExists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
+
+// backups lists the rotated files for the log file, oldest first.
+func (f *RotatingFile) backups() ([]string, error) {
+	ext := filepath.Ext(f.Filename)
+	base := filepath.Base(strings.TrimSuffix(f.Filename, ext))
+	dir := filepath.Dir(f.Filename)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	backups := []string{}
+	for _, entry := range entries {
+		name := strings.TrimSuffix(entry.Name(), ".gz")
+		if entry.IsDir() || !strings.HasPrefix(name, base+"-") || !strings.HasSuffix(name, ext) {
+			continue
+		}
+		stamp := strings.TrimSuffix(strings.TrimPrefix(name, base+"-"), ext)
+		if _, err := time.Parse(backupTimeFormat, stamp); err != nil {
+			continue
+		}
+		backups = append(backups, filepath.Join(dir, entry.Name()))
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		return strings.TrimSuffix(backups[i], ".gz") < strings.TrimSuffix(backups[j], ".gz")
+	})
+	return backups, nil
+}
+
+func compressFile(name string) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(name+".gz", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(dst)
+	_, err = io.Copy(gz, src)
+	if err == nil {
+		err = gz.Close()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(name + ".gz")
+		return err
+	}
+	return os.Remove(name)
+}
@@ -0,0 +1,123 @@
+package entre
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func Test_RotatingFileSize(t *testing.T) {
+	dir := t.TempDir()
+	f, err := NewRotatingFile(filepath.Join(dir, "access.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.MaxSize = 10
+	f.MaxBackups = 2
+	for _, line := range []string{"line one\n", "line two\n", "line three\n", "line four\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Error(err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		t.Error(err)
+	}
+	backups, err := f.backups()
+	if err != nil {
+		t.Error(err)
+	}
+	expect(t, len(backups), 2)
+	current, _ := os.ReadFile(filepath.Join(dir, "access.log"))
+	expect(t, string(current), "line four\n")
+	newest, _ := os.ReadFile(backups[1])
+	expect(t, string(newest), "line three\n")
+}
+
+func Test_RotatingFileAge(t *testing.T) {
+	dir := t.TempDir()
+	f, err := NewRotatingFile(filepath.Join(dir, "access.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.MaxAge = time.Millisecond
+	f.Write([]byte("first\n"))
+	time.Sleep(5 * time.Millisecond)
+	f.Write([]byte("second\n"))
+	f.Close()
+	backups, _ := f.backups()
+	expect(t, len(backups), 1)
+}
+
+func Test_RotatingFileCompress(t *testing.T) {
+	dir := t.TempDir()
+	f, err := NewRotatingFile(filepath.Join(dir, "access.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Compress = true
+	f.Write([]byte("compress me\n"))
+	if err := f.Rotate(); err != nil {
+		t.Error(err)
+	}
+	f.Close()
+	backups, _ := f.backups()
+	expect(t, len(backups), 1)
+	expect(t, strings.HasSuffix(backups[0], ".log.gz"), true)
+	gzFile, err := os.Open(backups[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer gzFile.Close()
+	gz, err := gzip.NewReader(gzFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ := io.ReadAll(gz)
+	expect(t, string(content), "compress me\n")
+}
+
+func Test_RotatingFileReopenOnSignal(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "access.log")
+	f, err := NewRotatingFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	f.ReopenOnSignal()
+	f.Write([]byte("before\n"))
+	// Emulate logrotate moving the file out of the way.
+	os.Rename(name, name+".1")
+	p, _ := os.FindProcess(os.Getpid())
+	if err := p.Signal(syscall.SIGHUP); err != nil {
+		t.Skip("unable to signal the current process:", err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for !fileExists(name) && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	f.Write([]byte("after\n"))
+	current, _ := os.ReadFile(name)
+	expect(t, string(current), "after\n")
+}
+
+func Test_RotatingFileWriteAfterClose(t *testing.T) {
+	dir := t.TempDir()
+	f, err := NewRotatingFile(filepath.Join(dir, "access.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("before\n"))
+	f.Close()
+	_, err = f.Write([]byte("after\n"))
+	expect(t, err, os.ErrClosed)
+	expect(t, f.file == nil, true)
+	expect(t, f.Reopen(), os.ErrClosed)
+	current, _ := os.ReadFile(filepath.Join(dir, "access.log"))
+	expect(t, string(current), "before\n")
+}
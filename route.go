@@ -0,0 +1,40 @@
+package entre
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+type routeKey struct{}
+
+// UseRoute provides a handler which records the route pattern that the rest of
+// the middleware chain is serving. This is primarily for route specific stacks
+// used with ForHTTPRouter where the matched route pattern isn't otherwise available.
+//
+//	router.GET("/users/:id", entre.New(entre.UseRoute("/users/:id"), ...).ForHTTPRouter())
+func UseRoute(route string) Handler {
+	return HandlerFunc(func(w http.ResponseWriter, r *http.Request, ps httprouter.Params, next http.HandlerFunc) {
+		next(w, WithRoute(r, route))
+	})
+}
+
+// WithRoute provides a shallow copy of the given request with the route pattern
+// stored in its context.
+func WithRoute(r *http.Request, route string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), routeKey{}, route))
+}
+
+// RouteFrom retrieves the route pattern for the provided request.
+// A route recorded with UseRoute or WithRoute takes precedence over the pattern
+// set by http.ServeMux, an empty string is returned when neither is available.
+func RouteFrom(r *http.Request) string {
+	if r == nil {
+		return ""
+	}
+	if route, ok := r.Context().Value(routeKey{}).(string); ok {
+		return route
+	}
+	return r.Pattern
+}
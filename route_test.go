@@ -0,0 +1,35 @@
+package entre
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_UseRoute(t *testing.T) {
+	var route string
+	e := New(UseRoute("/entity/:id"))
+	e.PushHandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route = RouteFrom(r)
+	})
+	req, err := http.NewRequest("GET", "http://localhost:8384/entity/1", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	e.ServeHTTP(httptest.NewRecorder(), req)
+	expect(t, route, "/entity/:id")
+}
+
+func Test_RouteFromServeMux(t *testing.T) {
+	var route string
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /entity/{id}", func(w http.ResponseWriter, r *http.Request) {
+		route = RouteFrom(r)
+	})
+	req, err := http.NewRequest("GET", "http://localhost:8384/entity/1", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	mux.ServeHTTP(httptest.NewRecorder(), req)
+	expect(t, route, "GET /entity/{id}")
+}
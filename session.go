@@ -0,0 +1,419 @@
+package entre
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+type sessionKey struct{}
+
+// minSessionKeyLength is the least number of bytes a session key can have.
+const minSessionKeyLength = 32
+
+// maxCookieSize is the largest cookie value browsers can be relied upon to store.
+const maxCookieSize = 4096
+
+// SessionData is the persisted state of a session.
+type SessionData struct {
+	Values   map[string]interface{} `json:"values"`
+	Created  time.Time              `json:"created"`
+	LastSeen time.Time              `json:"last_seen"`
+}
+
+// Session provides access to the session of a request, see SessionFrom.
+// Values are persisted as JSON so numbers read back from a stored session are float64s.
+type Session struct {
+	mu          sync.Mutex
+	id          string
+	data        *SessionData
+	isNew       bool
+	regenerated bool
+	destroyed   bool
+	saved       bool
+}
+
+// SessionFrom retrieves the session for the provided request,
+// nil when the request isn't being served by the sessions middleware.
+func SessionFrom(r *http.Request) *Session {
+	s, _ := r.Context().Value(sessionKey{}).(*Session)
+	return s
+}
+
+// Get retrieves the value stored under the provided key.
+func (s *Session) Get(key string) interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.Values[key]
+}
+
+// Set stores the provided value under the provided key.
+func (s *Session) Set(key string, value interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Values[key] = value
+}
+
+// Delete removes the value stored under the provided key.
+func (s *Session) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.data.Values, key)
+}
+
+// IsNew reports whether or not the session was started by this request.
+func (s *Session) IsNew() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.isNew
+}
+
+// Regenerate gives the session a new ID while keeping its values. This should be called
+// whenever the privileges of the session change, such as when logging in, to prevent session fixation.
+// Without a Store sessions have no ID and cookies already issued stay valid until they time out,
+// so regenerating a session can't revoke them.
+func (s *Session) Regenerate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.regenerated = true
+}
+
+// Destroy removes every value from the session and expires its cookie,
+// such as when logging out. Without a Store only the client's own cookie is expired,
+// copies of it taken before stay valid until they time out.
+func (s *Session) Destroy() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Values = map[string]interface{}{}
+	s.destroyed = true
+}
+
+// SessionStore stores session data server-side for the sessions middleware.
+type SessionStore interface {
+	// Load provides the data of the session with the provided ID,
+	// nil when there is no such session or it has expired.
+	Load(id string) (*SessionData, error)
+	// Save stores the data of the session with the provided ID until it expires,
+	// which is never when expires is zero.
+	Save(id string, data *SessionData, expires time.Time) error
+	// Delete removes the session with the provided ID.
+	Delete(id string) error
+}
+
+// Sessions provides the session middleware. Sessions are stored in cookies encrypted with
+// AES-GCM and authenticated with HMAC-SHA256. When a Store is set the cookie only holds the
+// session ID and the session data is kept in the store, otherwise the data itself is kept in the cookie.
+// The session is saved just before the response headers are written.
+type Sessions struct {
+	// Name is the name of the session cookie.
+	Name string
+	// Keys are the secrets sessions are encrypted and authenticated with. The first key is used
+	// to encode sessions and every key is tried when decoding them, so keys can be rotated
+	// by adding a new key to the front and removing the old one once its sessions have expired.
+	Keys [][]byte
+	// Store keeps the session data server-side, when set.
+	Store SessionStore
+	// IdleTimeout is how long a session lasts without being used.
+	IdleTimeout time.Duration
+	// AbsoluteTimeout is how long a session lasts from when it was started, however much it is used.
+	AbsoluteTimeout time.Duration
+	// Path, Domain, Secure, HttpOnly and SameSite are the attributes of the session cookie.
+	Path     string
+	Domain   string
+	Secure   bool
+	HttpOnly bool
+	SameSite http.SameSite
+	// Logger is where errors loading and saving sessions are logged to, when set.
+	Logger LoggerIface
+}
+
+// NewSessions creates a new sessions instance with the provided keys, which must be
+// at least 32 bytes long. Sessions time out after 30 minutes of inactivity and after
+// 24 hours regardless, and the cookie is only sent over HTTPS and can't be read by scripts.
+// It panics when no keys are provided or any of them is too short, as no session could be saved.
+func NewSessions(keys ...[]byte) *Sessions {
+	if len(keys) == 0 {
+		panic("At least one session key must be provided")
+	}
+	for _, key := range keys {
+		if len(key) < minSessionKeyLength {
+			panic(fmt.Sprintf("Session keys must be at least %d bytes long, not %d", minSessionKeyLength, len(key)))
+		}
+	}
+	return &Sessions{
+		Name:            "session",
+		Keys:            keys,
+		IdleTimeout:     30 * time.Minute,
+		AbsoluteTimeout: 24 * time.Hour,
+		Path:            "/",
+		Secure:          true,
+		HttpOnly:        true,
+		SameSite:        http.SameSiteLaxMode,
+	}
+}
+
+func (s *Sessions) ServeHTTP(w http.ResponseWriter, r *http.Request, ps httprouter.Params, next http.HandlerFunc) {
+	resp := responseFor(w)
+	session := s.load(r)
+	resp.Before(func(resp Response) {
+		s.save(resp, r, session)
+	})
+	next(resp, r.WithContext(context.WithValue(r.Context(), sessionKey{}, session)))
+	// Responses with nothing written to them never call the Before hook.
+	s.save(resp, r, session)
+}
+
+// load loads the session from the request's cookie, starting a new session
+// when there isn't a valid one.
+func (s *Sessions) load(r *http.Request) *Session {
+	now := time.Now()
+	if cookie, err := r.Cookie(s.Name); err == nil {
+		id, data, err := s.decode(cookie.Value)
+		if err != nil {
+			s.logError(r, "decoding", err)
+		} else if data != nil && s.expired(data, now) {
+			if id != "" {
+				s.Store.Delete(id)
+			}
+		} else if data != nil {
+			return &Session{id: id, data: data}
+		}
+	}
+	return &Session{
+		data:  &SessionData{Values: map[string]interface{}{}, Created: now, LastSeen: now},
+		isNew: true,
+	}
+}
+
+func (s *Sessions) expired(data *SessionData, now time.Time) bool {
+	return (s.IdleTimeout > 0 && now.Sub(data.LastSeen) > s.IdleTimeout) ||
+		(s.AbsoluteTimeout > 0 && now.Sub(data.Created) > s.AbsoluteTimeout)
+}
+
+// save writes the session cookie and stores the session, only the first call for a request has any effect.
+func (s *Sessions) save(w http.ResponseWriter, r *http.Request, session *Session) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	if session.saved {
+		return
+	}
+	session.saved = true
+	cookie := &http.Cookie{
+		Name:     s.Name,
+		Path:     s.Path,
+		Domain:   s.Domain,
+		Secure:   s.Secure,
+		HttpOnly: s.HttpOnly,
+		SameSite: s.SameSite,
+	}
+	if session.destroyed {
+		if session.id != "" {
+			if err := s.Store.Delete(session.id); err != nil {
+				s.logError(r, "deleting", err)
+			}
+		}
+		if !session.isNew {
+			cookie.MaxAge = -1
+			http.SetCookie(w, cookie)
+		}
+		return
+	}
+	data := session.data
+	if session.isNew && len(data.Values) == 0 {
+		// Cookies aren't set for visitors until there is something to remember about them.
+		return
+	}
+	data.LastSeen = time.Now()
+	var expires time.Time
+	if s.IdleTimeout > 0 {
+		expires = data.LastSeen.Add(s.IdleTimeout)
+	}
+	if absolute := data.Created.Add(s.AbsoluteTimeout); s.AbsoluteTimeout > 0 && (expires.IsZero() || absolute.Before(expires)) {
+		expires = absolute
+	}
+	value, err := s.encode(session, expires)
+	if err != nil {
+		s.logError(r, "saving", err)
+		return
+	}
+	cookie.Value = value
+	cookie.Expires = expires
+	http.SetCookie(w, cookie)
+}
+
+// encode stores the session when there is a store and encodes the session cookie value.
+func (s *Sessions) encode(session *Session, expires time.Time) (string, error) {
+	if len(s.Keys) == 0 {
+		return "", errors.New("no session keys configured")
+	}
+	var plaintext []byte
+	if s.Store != nil {
+		if session.regenerated || session.id == "" {
+			if session.id != "" {
+				s.Store.Delete(session.id)
+			}
+			id, err := newSessionID()
+			if err != nil {
+				return "", err
+			}
+			session.id = id
+		}
+		if err := s.Store.Save(session.id, session.data, expires); err != nil {
+			return "", err
+		}
+		plaintext = []byte("id:" + session.id)
+	} else {
+		data, err := json.Marshal(session.data)
+		if err != nil {
+			return "", err
+		}
+		plaintext = append([]byte("data:"), data...)
+	}
+	value, err := sealCookie(s.Keys[0], s.Name, plaintext)
+	if err != nil {
+		return "", err
+	}
+	if len(value) > maxCookieSize {
+		return "", fmt.Errorf("session cookie of %d bytes is too large, consider using a session store", len(value))
+	}
+	return value, nil
+}
+
+// decode decodes a session cookie value, loading the session from the store when there is one.
+func (s *Sessions) decode(value string) (string, *SessionData, error) {
+	var plaintext []byte
+	var err error
+	for _, key := range s.Keys {
+		if plaintext, err = openCookie(key, s.Name, value); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	if id, ok := strings.CutPrefix(string(plaintext), "id:"); ok && s.Store != nil {
+		data, err := s.Store.Load(id)
+		if err != nil || data == nil {
+			return "", nil, err
+		}
+		if data.Values == nil {
+			data.Values = map[string]interface{}{}
+		}
+		return id, data, nil
+	}
+	if encoded, ok := strings.CutPrefix(string(plaintext), "data:"); ok && s.Store == nil {
+		data := &SessionData{}
+		if err := json.Unmarshal([]byte(encoded), data); err != nil {
+			return "", nil, err
+		}
+		if data.Values == nil {
+			data.Values = map[string]interface{}{}
+		}
+		return "", data, nil
+	}
+	// Sessions from before a store was added or removed are discarded.
+	return "", nil, nil
+}
+
+func (s *Sessions) logError(r *http.Request, action string, err error) {
+	if s.Logger != nil {
+		logf(s.Logger, SeverityError, "%sError %s session: %s", requestIDLogPrefix(r), action, err)
+	}
+}
+
+var errInvalidCookie = errors.New("invalid session cookie")
+
+// sessionCookieKeys derives the encryption and authentication keys from a session key.
+func sessionCookieKeys(key []byte) (encryption []byte, authentication []byte) {
+	derive := func(purpose string) []byte {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(purpose))
+		return mac.Sum(nil)
+	}
+	return derive("entre session encryption"), derive("entre session authentication")
+}
+
+// sealCookie encrypts the plaintext with AES-GCM and authenticates the result along with
+// the cookie name with HMAC-SHA256, so cookies can't be swapped between names.
+func sealCookie(key []byte, name string, plaintext []byte) (string, error) {
+	if len(key) < minSessionKeyLength {
+		return "", fmt.Errorf("session keys must be at least %d bytes", minSessionKeyLength)
+	}
+	encryption, authentication := sessionCookieKeys(key)
+	block, err := aes.NewCipher(encryption)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(gcm.Seal(nonce, nonce, plaintext, []byte(name)))
+	mac := hmac.New(sha256.New, authentication)
+	mac.Write([]byte(name + "|" + payload))
+	return payload + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// openCookie authenticates and decrypts a cookie value sealed with sealCookie.
+func openCookie(key []byte, name string, value string) ([]byte, error) {
+	payload, signature, ok := strings.Cut(value, ".")
+	if !ok {
+		return nil, errInvalidCookie
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return nil, errInvalidCookie
+	}
+	encryption, authentication := sessionCookieKeys(key)
+	mac := hmac.New(sha256.New, authentication)
+	mac.Write([]byte(name + "|" + payload))
+	if !hmac.Equal(mac.Sum(nil), sig) {
+		return nil, errInvalidCookie
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, errInvalidCookie
+	}
+	block, err := aes.NewCipher(encryption)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errInvalidCookie
+	}
+	plaintext, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], []byte(name))
+	if err != nil {
+		return nil, errInvalidCookie
+	}
+	return plaintext, nil
+}
+
+func newSessionID() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
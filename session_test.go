@@ -0,0 +1,197 @@
+package entre
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	testSessionKey = []byte("0123456789abcdef0123456789abcdef")
+	oldSessionKey  = []byte("fedcba9876543210fedcba9876543210")
+)
+
+// sessionClient serves requests carrying the session cookie set by earlier responses.
+type sessionClient struct {
+	t      *testing.T
+	e      *Entre
+	cookie *http.Cookie
+}
+
+func (c *sessionClient) serve(path string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest("GET", "http://localhost:8384"+path, nil)
+	if err != nil {
+		c.t.Error(err)
+	}
+	if c.cookie != nil {
+		req.AddCookie(c.cookie)
+	}
+	recorder := httptest.NewRecorder()
+	c.e.ServeHTTP(recorder, req)
+	for _, cookie := range recorder.Result().Cookies() {
+		if cookie.MaxAge < 0 {
+			c.cookie = nil
+		} else {
+			c.cookie = cookie
+		}
+	}
+	return recorder
+}
+
+func newSessionApp(sessions *Sessions) *Entre {
+	e := New(sessions)
+	e.PushHandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session := SessionFrom(r)
+		switch r.URL.Path {
+		case "/login":
+			session.Regenerate()
+			session.Set("user", "jane")
+			w.WriteHeader(http.StatusNoContent)
+		case "/logout":
+			session.Destroy()
+		case "/whoami":
+			user, _ := session.Get("user").(string)
+			w.Write([]byte(user))
+		}
+	})
+	return e
+}
+
+func Test_SessionsCookie(t *testing.T) {
+	sessions := NewSessions(testSessionKey)
+	client := &sessionClient{t: t, e: newSessionApp(sessions)}
+
+	// No cookie is set until there is something in the session.
+	client.serve("/whoami")
+	expect(t, client.cookie == nil, true)
+
+	client.serve("/login")
+	refute(t, client.cookie == nil, true)
+	expect(t, client.cookie.Secure, true)
+	expect(t, client.cookie.HttpOnly, true)
+	expect(t, client.cookie.SameSite, http.SameSiteLaxMode)
+	expect(t, strings.Contains(client.cookie.Value, "jane"), false)
+	expect(t, client.serve("/whoami").Body.String(), "jane")
+
+	// Tampered cookies are rejected.
+	value := client.cookie.Value
+	tampered := "A"
+	if value[0] == 'A' {
+		tampered = "B"
+	}
+	client.cookie.Value = tampered + value[1:]
+	expect(t, client.serve("/whoami").Body.String(), "")
+	client.cookie.Value = value
+
+	// Cookies sealed with a key being rotated out are still accepted.
+	sessions.Keys = [][]byte{oldSessionKey, testSessionKey}
+	expect(t, client.serve("/whoami").Body.String(), "jane")
+	sessions.Keys = [][]byte{oldSessionKey}
+	expect(t, client.serve("/whoami").Body.String(), "jane")
+
+	client.serve("/logout")
+	expect(t, client.cookie == nil, true)
+	expect(t, client.serve("/whoami").Body.String(), "")
+}
+
+func Test_SessionsTimeouts(t *testing.T) {
+	sessions := NewSessions(testSessionKey)
+	sessions.IdleTimeout = 50 * time.Millisecond
+	client := &sessionClient{t: t, e: newSessionApp(sessions)}
+	client.serve("/login")
+	time.Sleep(30 * time.Millisecond)
+	// Using the session keeps it alive.
+	expect(t, client.serve("/whoami").Body.String(), "jane")
+	time.Sleep(30 * time.Millisecond)
+	expect(t, client.serve("/whoami").Body.String(), "jane")
+	time.Sleep(60 * time.Millisecond)
+	expect(t, client.serve("/whoami").Body.String(), "")
+
+	sessions.IdleTimeout = time.Hour
+	sessions.AbsoluteTimeout = 50 * time.Millisecond
+	client.serve("/login")
+	for i := 0; i < 3; i++ {
+		time.Sleep(20 * time.Millisecond)
+		client.serve("/whoami")
+	}
+	expect(t, client.serve("/whoami").Body.String(), "")
+}
+
+func Test_SessionsStore(t *testing.T) {
+	dir := t.TempDir()
+	fileStore, err := NewFileSessionStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, store := range []SessionStore{NewMemorySessionStore(), fileStore} {
+		sessions := NewSessions(testSessionKey)
+		sessions.Store = store
+		client := &sessionClient{t: t, e: newSessionApp(sessions)}
+		client.serve("/login")
+		first := client.cookie
+		expect(t, client.serve("/whoami").Body.String(), "jane")
+
+		// Logging in again regenerates the session ID, leaving the old one unusable.
+		client.serve("/login")
+		refute(t, client.cookie.Value, first.Value)
+		id, _, _ := sessions.decode(client.cookie.Value)
+		refute(t, id, "")
+		saved, _ := store.Load(id)
+		expect(t, saved.Values["user"], "jane")
+		second := client.cookie
+		client.cookie = first
+		expect(t, client.serve("/whoami").Body.String(), "")
+		client.cookie = second
+		expect(t, client.serve("/whoami").Body.String(), "jane")
+
+		client.serve("/logout")
+		saved, _ = store.Load(id)
+		expect(t, saved == nil, true)
+	}
+}
+
+func Test_FileSessionStore(t *testing.T) {
+	store, err := NewFileSessionStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	refute(t, store.Save("../escape", &SessionData{}, time.Time{}), nil)
+	data := &SessionData{Values: map[string]interface{}{"count": 1}}
+	expect(t, store.Save("ab12", data, time.Now().Add(-time.Second)), nil)
+	expect(t, store.Save("cd34", data, time.Time{}), nil)
+	expect(t, store.Cleanup(), nil)
+	_, err = os.Stat(store.Dir + "/session-ab12")
+	expect(t, os.IsNotExist(err), true)
+	loaded, _ := store.Load("cd34")
+	expect(t, loaded.Values["count"], float64(1))
+}
+
+func Test_SessionsCookieTooLarge(t *testing.T) {
+	buf := bytes.NewBufferString("")
+	sessions := NewSessions(testSessionKey)
+	sessions.Logger = log.New(buf, "|-entre-|", 0)
+	e := New(sessions)
+	e.PushHandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		SessionFrom(r).Set("large", strings.Repeat("x", 5000))
+	})
+	client := &sessionClient{t: t, e: e}
+	client.serve("/")
+	expect(t, client.cookie == nil, true)
+	expect(t, strings.Contains(buf.String(), "too large"), true)
+}
+
+func Test_NewSessionsKeyLength(t *testing.T) {
+	for _, keys := range [][][]byte{nil, {[]byte("too short")}, {testSessionKey, []byte("too short")}} {
+		func() {
+			defer func() {
+				refute(t, recover(), nil)
+			}()
+			NewSessions(keys...)
+		}()
+	}
+}
@@ -0,0 +1,192 @@
+package entre
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemorySessionStore is an in-memory SessionStore, sessions are lost when the process exits.
+type MemorySessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]*storedSession
+	pruned   time.Time
+}
+
+// storedSession is a session as kept by the session stores.
+type storedSession struct {
+	Data    []byte    `json:"data"`
+	Expires time.Time `json:"expires"`
+}
+
+func (s *storedSession) expired(now time.Time) bool {
+	return !s.Expires.IsZero() && now.After(s.Expires)
+}
+
+// NewMemorySessionStore creates a new empty in-memory session store.
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{sessions: map[string]*storedSession{}}
+}
+
+// Load provides the data of the session with the provided ID.
+func (s *MemorySessionStore) Load(id string) (*SessionData, error) {
+	s.mu.Lock()
+	stored, ok := s.sessions[id]
+	s.mu.Unlock()
+	if !ok || stored.expired(time.Now()) {
+		return nil, nil
+	}
+	// Sessions are kept encoded so the stored data isn't shared between requests.
+	data := &SessionData{}
+	return data, json.Unmarshal(stored.Data, data)
+}
+
+// Save stores the data of the session with the provided ID until it expires.
+func (s *MemorySessionStore) Save(id string, data *SessionData, expires time.Time) error {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sessions == nil {
+		s.sessions = map[string]*storedSession{}
+	}
+	now := time.Now()
+	// Expired sessions are cleared out every so often.
+	if now.Sub(s.pruned) > time.Minute {
+		for id, stored := range s.sessions {
+			if stored.expired(now) {
+				delete(s.sessions, id)
+			}
+		}
+		s.pruned = now
+	}
+	s.sessions[id] = &storedSession{Data: encoded, Expires: expires}
+	return nil
+}
+
+// Delete removes the session with the provided ID.
+func (s *MemorySessionStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, id)
+	return nil
+}
+
+// FileSessionStore is a SessionStore keeping each session in a file in Dir.
+// Expired sessions are removed as they are loaded or when Cleanup is called.
+type FileSessionStore struct {
+	// Dir is the directory sessions are kept in.
+	Dir string
+}
+
+// NewFileSessionStore creates a new file session store in the provided directory,
+// creating the directory when it doesn't exist.
+func NewFileSessionStore(dir string) (*FileSessionStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &FileSessionStore{Dir: dir}, nil
+}
+
+// Load provides the data of the session with the provided ID.
+func (s *FileSessionStore) Load(id string) (*SessionData, error) {
+	filename, err := s.filename(id)
+	if err != nil {
+		return nil, err
+	}
+	content, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	stored := &storedSession{}
+	if err := json.Unmarshal(content, stored); err != nil {
+		return nil, err
+	}
+	if stored.expired(time.Now()) {
+		os.Remove(filename)
+		return nil, nil
+	}
+	data := &SessionData{}
+	return data, json.Unmarshal(stored.Data, data)
+}
+
+// Save stores the data of the session with the provided ID until it expires.
+// The session file is replaced atomically so concurrent loads never see a partial write.
+func (s *FileSessionStore) Save(id string, data *SessionData, expires time.Time) error {
+	filename, err := s.filename(id)
+	if err != nil {
+		return err
+	}
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	content, err := json.Marshal(&storedSession{Data: encoded, Expires: expires})
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(s.Dir, ".session-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filename)
+}
+
+// Delete removes the session with the provided ID.
+func (s *FileSessionStore) Delete(id string) error {
+	filename, err := s.filename(id)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Cleanup removes every expired session, this can be called periodically.
+func (s *FileSessionStore) Cleanup() error {
+	matches, err := filepath.Glob(filepath.Join(s.Dir, "session-*"))
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, filename := range matches {
+		content, err := os.ReadFile(filename)
+		if err != nil {
+			continue
+		}
+		stored := &storedSession{}
+		if json.Unmarshal(content, stored) != nil || stored.expired(now) {
+			os.Remove(filename)
+		}
+	}
+	return nil
+}
+
+// filename provides the file the session with the provided ID is kept in.
+// IDs are checked to be hex so they can't be used to reach outside of Dir.
+func (s *FileSessionStore) filename(id string) (string, error) {
+	if _, err := hex.DecodeString(id); err != nil || id == "" || strings.ContainsAny(id, `/\.`) {
+		return "", errors.New("invalid session ID")
+	}
+	return filepath.Join(s.Dir, "session-"+id), nil
+}
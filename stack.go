@@ -0,0 +1,123 @@
+package entre
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"reflect"
+	"runtime"
+	"strings"
+)
+
+// entrePkg is the import path of this package, used to filter
+// entre's own frames out of panic stack traces.
+var entrePkg = reflect.TypeOf(Entre{}).PkgPath()
+
+// StackFrame is a single frame of a panic's stack trace.
+type StackFrame struct {
+	Function string `json:"function"`
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+}
+
+// String formats the frame the same way as frames of runtime stack traces.
+func (f StackFrame) String() string {
+	return fmt.Sprintf("%s\n\t%s:%d", f.Function, f.File, f.Line)
+}
+
+// captureStack captures the stack of the panicking goroutine from where the panic occurred,
+// leaving out the frames of the recovery itself and those belonging to entre's middleware.
+// This is to be called from within the deferred function that recovered the panic.
+func captureStack() []StackFrame {
+	pcs := make([]uintptr, 64)
+	for {
+		n := runtime.Callers(2, pcs)
+		if n < len(pcs) {
+			pcs = pcs[:n]
+			break
+		}
+		pcs = make([]uintptr, len(pcs)*2)
+	}
+	all := []StackFrame{}
+	panicked := -1
+	frames := runtime.CallersFrames(pcs)
+	for {
+		frame, more := frames.Next()
+		if frame.Function == "runtime.gopanic" {
+			panicked = len(all)
+		}
+		all = append(all, StackFrame{Function: frame.Function, File: frame.File, Line: frame.Line})
+		if !more {
+			break
+		}
+	}
+	stack := []StackFrame{}
+	for _, frame := range all[panicked+1:] {
+		if !isEntreFrame(frame) {
+			stack = append(stack, frame)
+		}
+	}
+	return stack
+}
+
+// isEntreFrame determines whether a frame belongs to entre itself,
+// test code in the package is not treated as part of entre.
+func isEntreFrame(frame StackFrame) bool {
+	if strings.HasSuffix(frame.File, "_test.go") {
+		return false
+	}
+	fn := frame.Function
+	return strings.HasPrefix(fn, entrePkg+".") && !strings.Contains(fn[len(entrePkg)+1:], "/")
+}
+
+// rawStack captures the formatted stack trace of the current goroutine,
+// or every goroutine when all is true. The buffer starts at the provided size
+// and grows until the complete trace fits.
+func rawStack(size int, all bool) []byte {
+	if size <= 0 {
+		size = 1024 * 12
+	}
+	for {
+		buf := make([]byte, size)
+		n := runtime.Stack(buf, all)
+		if n < size {
+			return buf[:n]
+		}
+		size *= 2
+	}
+}
+
+// formatStack formats frames the same way as runtime stack traces.
+func formatStack(frames []StackFrame) string {
+	var b strings.Builder
+	for _, frame := range frames {
+		b.WriteString(frame.String())
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// SourceLine is a line of source code shown around a stack frame.
+type SourceLine struct {
+	Number  int
+	Code    string
+	Current bool
+}
+
+// sourceSnippet reads the lines of source code surrounding the provided line,
+// nil is returned when the source isn't available.
+func sourceSnippet(file string, line int, context int) []SourceLine {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	lines := []SourceLine{}
+	scanner := bufio.NewScanner(f)
+	for number := 1; scanner.Scan() && number <= line+context; number++ {
+		if number >= line-context {
+			lines = append(lines, SourceLine{Number: number, Code: scanner.Text(), Current: number == line})
+		}
+	}
+	return lines
+}
@@ -0,0 +1,51 @@
+package entre
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func panickingHandler() {
+	panic("You have caused a panic")
+}
+
+func Test_CaptureStack(t *testing.T) {
+	var frames []StackFrame
+	func() {
+		defer func() {
+			recover()
+			frames = captureStack()
+		}()
+		panickingHandler()
+	}()
+	refute(t, len(frames), 0)
+	// The stack must start from where the panic occurred.
+	expect(t, frames[0].Function, entrePkg+".panickingHandler")
+	expect(t, strings.HasSuffix(frames[0].File, "stack_test.go"), true)
+	for _, frame := range frames {
+		expect(t, strings.HasPrefix(frame.Function, "runtime.gopanic"), false)
+	}
+}
+
+func Test_IsEntreFrame(t *testing.T) {
+	expect(t, isEntreFrame(StackFrame{Function: entrePkg + ".middleware.ServeHTTP", File: "/src/entre/entre.go"}), true)
+	expect(t, isEntreFrame(StackFrame{Function: entrePkg + ".Test_CaptureStack", File: "/src/entre/stack_test.go"}), false)
+	expect(t, isEntreFrame(StackFrame{Function: entrePkg + "/sub.Func", File: "/src/entre/sub/sub.go"}), false)
+	expect(t, isEntreFrame(StackFrame{Function: "net/http.HandlerFunc.ServeHTTP", File: "/go/src/net/http/server.go"}), false)
+}
+
+func Test_RawStackNotTruncated(t *testing.T) {
+	stack := rawStack(16, false)
+	expect(t, strings.HasPrefix(string(stack), "goroutine "), true)
+	expect(t, strings.Contains(string(stack), "Test_RawStackNotTruncated"), true)
+}
+
+func Test_SourceSnippet(t *testing.T) {
+	_, file, line, _ := runtime.Caller(0)
+	lines := sourceSnippet(file, line, 2)
+	expect(t, len(lines), 5)
+	expect(t, lines[2].Current, true)
+	expect(t, strings.Contains(lines[2].Code, "runtime.Caller(0)"), true)
+	expect(t, len(sourceSnippet("/does/not/exist.go", 10, 2)), 0)
+}
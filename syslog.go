@@ -0,0 +1,229 @@
+package entre
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Facility is a syslog facility as defined in RFC 5424.
+type Facility int
+
+// The syslog facilities.
+const (
+	FacilityKern Facility = iota
+	FacilityUser
+	FacilityMail
+	FacilityDaemon
+	FacilityAuth
+	FacilitySyslog
+	FacilityLPR
+	FacilityNews
+	FacilityUUCP
+	FacilityCron
+	FacilityAuthPriv
+	FacilityFTP
+	FacilityLocal0 Facility = iota + 4
+	FacilityLocal1
+	FacilityLocal2
+	FacilityLocal3
+	FacilityLocal4
+	FacilityLocal5
+	FacilityLocal6
+	FacilityLocal7
+)
+
+// Severity is a syslog severity as defined in RFC 5424.
+type Severity int
+
+// The syslog severities, from most to least severe.
+const (
+	SeverityEmergency Severity = iota
+	SeverityAlert
+	SeverityCritical
+	SeverityError
+	SeverityWarning
+	SeverityNotice
+	SeverityInfo
+	SeverityDebug
+)
+
+// SeverityLogger is implemented by loggers which can record messages at a given severity.
+// The bundled middleware check for this on the loggers they are given
+// so they can report at appropriate severities, access logs are reported as info
+// and panics as critical.
+type SeverityLogger interface {
+	LoggerIface
+	Logf(Severity, string, ...interface{})
+}
+
+// logf logs at the given severity when the logger supports severities,
+// otherwise it falls back to the logger's Printf.
+func logf(l LoggerIface, sev Severity, format string, v ...interface{}) {
+	if sl, ok := l.(SeverityLogger); ok {
+		sl.Logf(sev, format, v...)
+		return
+	}
+	l.Printf(format, v...)
+}
+
+// localSyslogAddrs are the paths the local syslog daemon usually listens on.
+var localSyslogAddrs = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+// Syslog is a LoggerIface which sends RFC 5424 formatted messages to a syslog daemon
+// over a unix socket or UDP. It implements SeverityLogger, messages logged with
+// Println and Printf are sent with the default Severity.
+type Syslog struct {
+	// Facility is the facility messages are sent with.
+	Facility Facility
+	// Severity is the severity used for messages logged with Println and Printf.
+	Severity Severity
+	// AppName identifies the application sending messages.
+	AppName string
+	// Hostname is sent as the origin of messages, this defaults to os.Hostname().
+	Hostname string
+
+	network string
+	addr    string
+	mu      sync.Mutex
+	conn    net.Conn
+	closed  bool
+}
+
+// NewSyslog connects to the syslog daemon at the provided address.
+// The network can be "udp", "unixgram" or "unix", when the network and address are empty
+// the local syslog daemon is connected to over its usual unix socket.
+func NewSyslog(network string, addr string, facility Facility, appName string) (*Syslog, error) {
+	hostname, _ := os.Hostname()
+	s := &Syslog{
+		Facility: facility,
+		Severity: SeverityInfo,
+		AppName:  appName,
+		Hostname: hostname,
+		network:  network,
+		addr:     addr,
+	}
+	if err := s.connect(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Println sends a message with the default severity.
+func (s *Syslog) Println(v ...interface{}) {
+	s.Logf(s.Severity, "%s", strings.TrimSuffix(fmt.Sprintln(v...), "\n"))
+}
+
+// Printf sends a message with the default severity.
+func (s *Syslog) Printf(format string, v ...interface{}) {
+	s.Logf(s.Severity, format, v...)
+}
+
+// Logf sends a message with the provided severity, see Send.
+func (s *Syslog) Logf(sev Severity, format string, v ...interface{}) {
+	s.Send(sev, fmt.Sprintf(format, v...))
+}
+
+// Send sends a message with the provided severity, when sending fails
+// the connection is re-established and the message is sent once more.
+// Sending after Close fails with os.ErrClosed.
+func (s *Syslog) Send(sev Severity, msg string) error {
+	t := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return os.ErrClosed
+	}
+	formatted := s.format(sev, t, msg)
+	if s.conn != nil {
+		if _, err := s.conn.Write(formatted); err == nil {
+			return nil
+		}
+		s.conn.Close()
+		s.conn = nil
+	}
+	if err := s.dial(); err != nil {
+		return err
+	}
+	_, err := s.conn.Write(formatted)
+	return err
+}
+
+// Close closes the connection to the syslog daemon, nothing more can be sent afterwards.
+func (s *Syslog) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+// format produces an RFC 5424 message. Messages sent over stream sockets are newline
+// terminated as expected by local syslog daemons, so newlines within them, such as those
+// of panic stacks, are escaped the way rsyslog escapes control characters to keep them one record.
+func (s *Syslog) format(sev Severity, t time.Time, msg string) []byte {
+	pri := int(s.Facility)*8 + int(sev)
+	if s.network == "unix" {
+		msg = strings.ReplaceAll(strings.TrimSuffix(msg, "\n"), "\n", "#012")
+	}
+	out := fmt.Sprintf("<%d>1 %s %s %s %d - - %s",
+		pri, t.Format("2006-01-02T15:04:05.000000Z07:00"), syslogField(s.Hostname, 255),
+		syslogField(s.AppName, 48), os.Getpid(), msg)
+	if s.network == "unix" {
+		out += "\n"
+	}
+	return []byte(out)
+}
+
+func (s *Syslog) connect() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dial()
+}
+
+func (s *Syslog) dial() error {
+	if s.network != "" || s.addr != "" {
+		conn, err := net.Dial(s.network, s.addr)
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+		return nil
+	}
+	for _, addr := range localSyslogAddrs {
+		for _, network := range []string{"unixgram", "unix"} {
+			if conn, err := net.Dial(network, addr); err == nil {
+				s.conn = conn
+				s.network = network
+				s.addr = addr
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("unable to connect to the local syslog daemon")
+}
+
+// syslogField provides a header field value which is valid for RFC 5424,
+// using the nil value when empty and capping the length.
+func syslogField(v string, max int) string {
+	v = strings.Map(func(r rune) rune {
+		if r < 33 || r > 126 {
+			return -1
+		}
+		return r
+	}, v)
+	if v == "" {
+		return "-"
+	}
+	if len(v) > max {
+		return v[:max]
+	}
+	return v
+}
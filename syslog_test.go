@@ -0,0 +1,141 @@
+package entre
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func readSyslogMessages(t *testing.T, conn net.PacketConn, n int) []string {
+	messages := []string{}
+	buf := make([]byte, 64*1024)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for len(messages) < n {
+		size, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		messages = append(messages, string(buf[:size]))
+	}
+	return messages
+}
+
+func Test_SyslogUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	s, err := NewSyslog("udp", conn.LocalAddr().String(), FacilityLocal0, "myapp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.Hostname = "myhost"
+	s.Printf("hello %s", "world")
+	s.Logf(SeverityCritical, "something went wrong")
+	messages := readSyslogMessages(t, conn, 2)
+	// local0 (16) * 8 + info (6)
+	expect(t, strings.HasPrefix(messages[0], "<134>1 "), true)
+	expect(t, strings.Contains(messages[0], " myhost myapp "), true)
+	expect(t, strings.HasSuffix(messages[0], " - - hello world"), true)
+	// local0 (16) * 8 + crit (2)
+	expect(t, strings.HasPrefix(messages[1], "<130>1 "), true)
+}
+
+func Test_SyslogMiddlewareSeverities(t *testing.T) {
+	dir := t.TempDir()
+	addr := filepath.Join(dir, "log.sock")
+	conn, err := net.ListenPacket("unixgram", addr)
+	if err != nil {
+		t.Skip("unix datagram sockets aren't supported:", err)
+	}
+	defer conn.Close()
+	s, err := NewSyslog("unixgram", addr, FacilityDaemon, "myapp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	l := NewLogger()
+	l.LoggerIface = s
+	pr := NewPanicRecovery(false)
+	pr.Logger = s
+	e := New(l, pr)
+	e.PushHandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("You have caused a panic")
+	})
+	req, err := http.NewRequest("GET", "http://localhost:8384/test", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	e.ServeHTTP(httptest.NewRecorder(), req)
+	messages := readSyslogMessages(t, conn, 3)
+	// daemon (3) * 8 + info (6)
+	expect(t, strings.HasPrefix(messages[0], "<30>1 "), true)
+	expect(t, strings.Contains(messages[0], "Began GET /test"), true)
+	// daemon (3) * 8 + crit (2)
+	expect(t, strings.HasPrefix(messages[1], "<26>1 "), true)
+	expect(t, strings.Contains(messages[1], "PANIC: You have caused a panic"), true)
+	expect(t, strings.HasPrefix(messages[2], "<30>1 "), true)
+	expect(t, strings.Contains(messages[2], "Completed with 500"), true)
+}
+
+func Test_SyslogStreamFraming(t *testing.T) {
+	dir := t.TempDir()
+	addr := filepath.Join(dir, "log.sock")
+	listener, err := net.Listen("unix", addr)
+	if err != nil {
+		t.Skip("unix sockets aren't supported:", err)
+	}
+	defer listener.Close()
+	s, err := NewSyslog("unix", addr, FacilityDaemon, "myapp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	s.Logf(SeverityCritical, "panic: boom\ngoroutine 1 [running]:\nmain.main()\n")
+	s.Close()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var received strings.Builder
+	buf := make([]byte, 1024)
+	for {
+		n, err := conn.Read(buf)
+		received.Write(buf[:n])
+		if err != nil {
+			break
+		}
+	}
+	// A multi-line message stays a single newline terminated record.
+	records := strings.Split(strings.TrimSuffix(received.String(), "\n"), "\n")
+	expect(t, len(records), 1)
+	expect(t, strings.HasSuffix(records[0], " - - panic: boom#012goroutine 1 [running]:#012main.main()"), true)
+}
+
+func Test_SyslogAfterClose(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	s, err := NewSyslog("udp", conn.LocalAddr().String(), FacilityLocal0, "myapp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect(t, s.Send(SeverityInfo, "before"), nil)
+	s.Close()
+	// Logging after Close doesn't reconnect.
+	s.Printf("after")
+	expect(t, s.Send(SeverityInfo, "after"), os.ErrClosed)
+	expect(t, s.conn == nil, true)
+	messages := readSyslogMessages(t, conn, 1)
+	expect(t, strings.HasSuffix(messages[0], " - - before"), true)
+}
@@ -0,0 +1,266 @@
+package entre
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// Errors webhook signatures are rejected with.
+var (
+	ErrSignatureMissing  = errors.New("missing signature")
+	ErrSignatureInvalid  = errors.New("invalid signature")
+	ErrSignatureExpired  = errors.New("signature timestamp outside of tolerance")
+	ErrSignatureReplayed = errors.New("signature has already been used")
+)
+
+// SignatureFormat describes how a webhook provider signs its requests.
+type SignatureFormat struct {
+	// Parse extracts the timestamp, if any, and the candidate signatures from the request.
+	Parse func(r *http.Request) (timestamp string, signatures [][]byte, err error)
+	// Payload provides the payload that is signed from the timestamp and body.
+	Payload func(timestamp string, body []byte) []byte
+}
+
+// GitHubSignature is the format of GitHub's webhook signatures, an X-Hub-Signature-256
+// header holding the hex encoded HMAC-SHA256 of the body prefixed with "sha256=".
+// As GitHub's signatures don't have a timestamp replays are only caught by the nonce cache,
+// which also rejects deliveries redelivered from GitHub within its TTL as they carry the same signature.
+// The X-GitHub-Delivery header can't be used to tell them apart as it isn't signed and is kept on
+// redelivery. Where redeliveries are needed, Nonces can be set to nil and handlers can deduplicate
+// deliveries by their X-GitHub-Delivery header instead.
+var GitHubSignature = &SignatureFormat{
+	Parse: func(r *http.Request) (string, [][]byte, error) {
+		signature, err := parseHexSignature(r.Header.Get("X-Hub-Signature-256"), "sha256=")
+		if err != nil {
+			return "", nil, err
+		}
+		return "", [][]byte{signature}, nil
+	},
+	Payload: bodyPayload,
+}
+
+// StripeSignature is the format of Stripe's webhook signatures, a Stripe-Signature header
+// like "t=1492774577,v1=5257a8...,v1=..." holding a timestamp and one or more hex encoded
+// HMAC-SHA256 signatures of the timestamp and body joined by a dot.
+var StripeSignature = &SignatureFormat{
+	Parse: func(r *http.Request) (string, [][]byte, error) {
+		header := r.Header.Get("Stripe-Signature")
+		if header == "" {
+			return "", nil, ErrSignatureMissing
+		}
+		timestamp := ""
+		var signatures [][]byte
+		for _, part := range strings.Split(header, ",") {
+			key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+			switch key {
+			case "t":
+				timestamp = value
+			case "v1":
+				if signature, err := hex.DecodeString(value); err == nil {
+					signatures = append(signatures, signature)
+				}
+			}
+		}
+		if timestamp == "" || len(signatures) == 0 {
+			return "", nil, ErrSignatureInvalid
+		}
+		return timestamp, signatures, nil
+	},
+	Payload: timestampedPayload,
+}
+
+// HMACSignature provides a format for signatures sent in the provided header as the
+// hex encoded HMAC-SHA256, optionally prefixed with "sha256=". When a timestamp header is
+// provided the signature is of the timestamp and body joined by a dot, otherwise of the body alone.
+func HMACSignature(header string, timestampHeader string) *SignatureFormat {
+	format := &SignatureFormat{Payload: bodyPayload}
+	if timestampHeader != "" {
+		format.Payload = timestampedPayload
+	}
+	format.Parse = func(r *http.Request) (string, [][]byte, error) {
+		signature, err := parseHexSignature(r.Header.Get(header), "sha256=")
+		if err != nil {
+			return "", nil, err
+		}
+		timestamp := ""
+		if timestampHeader != "" {
+			if timestamp = r.Header.Get(timestampHeader); timestamp == "" {
+				return "", nil, ErrSignatureMissing
+			}
+		}
+		return timestamp, [][]byte{signature}, nil
+	}
+	return format
+}
+
+func parseHexSignature(value string, prefix string) ([]byte, error) {
+	if value == "" {
+		return nil, ErrSignatureMissing
+	}
+	signature, err := hex.DecodeString(strings.TrimPrefix(value, prefix))
+	if err != nil {
+		return nil, ErrSignatureInvalid
+	}
+	return signature, nil
+}
+
+func bodyPayload(timestamp string, body []byte) []byte {
+	return body
+}
+
+func timestampedPayload(timestamp string, body []byte) []byte {
+	return append([]byte(timestamp+"."), body...)
+}
+
+// WebhookSignature provides the middleware verifying the HMAC-SHA256 signatures
+// of incoming webhooks. The body is buffered to verify it and restored for the rest of the chain.
+// Any of the Secrets are accepted so they can be rotated without downtime.
+type WebhookSignature struct {
+	// Format is how the webhook provider signs its requests.
+	Format *SignatureFormat
+	// Secrets are the currently active signing secrets.
+	Secrets []string
+	// Tolerance is how far the signature timestamp can be from now, not checked when 0.
+	Tolerance time.Duration
+	// Nonces remembers the signatures already seen to reject replays, when set.
+	// Providers' redeliveries of the same payload are rejected too, see GitHubSignature.
+	Nonces *NonceCache
+	// MaxBodyBytes is the largest body accepted.
+	MaxBodyBytes int64
+	// OnFailure is called with the reason for each rejected request, when set.
+	OnFailure func(r *http.Request, err error)
+}
+
+// NewWebhookSignature creates a new webhook signature instance for the provided format
+// accepting signatures made with any of the provided secrets. Timestamps must be within
+// 5 minutes, deliveries are remembered for 10 minutes to reject replays and
+// bodies are limited to 1MB.
+func NewWebhookSignature(format *SignatureFormat, secrets ...string) *WebhookSignature {
+	return &WebhookSignature{
+		Format:       format,
+		Secrets:      secrets,
+		Tolerance:    5 * time.Minute,
+		Nonces:       NewNonceCache(10 * time.Minute),
+		MaxBodyBytes: 1 << 20,
+	}
+}
+
+func (s *WebhookSignature) ServeHTTP(w http.ResponseWriter, r *http.Request, ps httprouter.Params, next http.HandlerFunc) {
+	var body []byte
+	var err error
+	// Requests made by hand for testing can have a nil body, which is taken as empty.
+	if r.Body != nil {
+		body, err = io.ReadAll(io.LimitReader(r.Body, s.MaxBodyBytes+1))
+		r.Body.Close()
+	}
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	if int64(len(body)) > s.MaxBodyBytes {
+		http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err := s.Verify(r, body); err != nil {
+		if s.OnFailure != nil {
+			s.OnFailure(r, err)
+		}
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+	r.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+	next(w, r)
+}
+
+// Verify verifies the signature of the provided request with the provided body.
+func (s *WebhookSignature) Verify(r *http.Request, body []byte) error {
+	timestamp, signatures, err := s.Format.Parse(r)
+	if err != nil {
+		return err
+	}
+	if timestamp != "" && s.Tolerance > 0 {
+		seconds, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil {
+			return ErrSignatureInvalid
+		}
+		if diff := time.Since(time.Unix(seconds, 0)); diff > s.Tolerance || diff < -s.Tolerance {
+			return ErrSignatureExpired
+		}
+	}
+	payload := s.Format.Payload(timestamp, body)
+	var matched []byte
+	for _, secret := range s.Secrets {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(payload)
+		expected := mac.Sum(nil)
+		for _, signature := range signatures {
+			if hmac.Equal(expected, signature) {
+				matched = signature
+			}
+		}
+	}
+	if matched == nil {
+		return ErrSignatureInvalid
+	}
+	// The signature is used as the nonce rather than something like a delivery ID header
+	// as it can't be changed without invalidating the signature.
+	if s.Nonces != nil {
+		if s.Nonces.Seen(hex.EncodeToString(matched)) {
+			return ErrSignatureReplayed
+		}
+	}
+	return nil
+}
+
+// NonceCache remembers nonces for a period of time to detect replays.
+type NonceCache struct {
+	// TTL is how long nonces are remembered for.
+	TTL time.Duration
+
+	mu     sync.Mutex
+	nonces map[string]time.Time
+	pruned time.Time
+}
+
+// NewNonceCache creates a new nonce cache remembering nonces for the provided period.
+func NewNonceCache(ttl time.Duration) *NonceCache {
+	return &NonceCache{TTL: ttl, nonces: map[string]time.Time{}}
+}
+
+// Seen records the provided nonce and reports whether or not it has already been seen.
+func (c *NonceCache) Seen(nonce string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if c.nonces == nil {
+		c.nonces = map[string]time.Time{}
+	}
+	if now.Sub(c.pruned) > c.TTL {
+		for n, expires := range c.nonces {
+			if now.After(expires) {
+				delete(c.nonces, n)
+			}
+		}
+		c.pruned = now
+	}
+	if expires, ok := c.nonces[nonce]; ok && now.Before(expires) {
+		return true
+	}
+	c.nonces[nonce] = now.Add(c.TTL)
+	return false
+}
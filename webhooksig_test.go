@@ -0,0 +1,118 @@
+package entre
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func hmacHex(secret string, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func Test_WebhookSignatureGitHub(t *testing.T) {
+	var received string
+	var failures []error
+	sig := NewWebhookSignature(GitHubSignature, "new-secret", "old-secret")
+	sig.OnFailure = func(r *http.Request, err error) {
+		failures = append(failures, err)
+	}
+	e := New(sig)
+	e.PushHandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received = string(body)
+	})
+	serve := func(body string, signature string) int {
+		req, err := http.NewRequest("POST", "http://localhost:8384/hooks", strings.NewReader(body))
+		if err != nil {
+			t.Error(err)
+		}
+		if signature != "" {
+			req.Header.Set("X-Hub-Signature-256", signature)
+		}
+		recorder := httptest.NewRecorder()
+		e.ServeHTTP(recorder, req)
+		return recorder.Code
+	}
+	body := `{"action":"opened"}`
+	expect(t, serve(body, "sha256="+hmacHex("new-secret", body)), http.StatusOK)
+	expect(t, received, body)
+	// Signatures made with the secret being rotated out are still accepted.
+	expect(t, serve(body, "sha256="+hmacHex("old-secret", body)), http.StatusOK)
+	// Replays of the same delivery are rejected.
+	expect(t, serve(body, "sha256="+hmacHex("new-secret", body)), http.StatusUnauthorized)
+	expect(t, serve(body, "sha256="+hmacHex("wrong-secret", body)), http.StatusUnauthorized)
+	expect(t, serve(body, ""), http.StatusUnauthorized)
+	expect(t, len(failures), 3)
+	expect(t, errors.Is(failures[0], ErrSignatureReplayed), true)
+	expect(t, errors.Is(failures[1], ErrSignatureInvalid), true)
+	expect(t, errors.Is(failures[2], ErrSignatureMissing), true)
+
+	sig.MaxBodyBytes = 4
+	expect(t, serve(body, "sha256="+hmacHex("new-secret", body)), http.StatusRequestEntityTooLarge)
+}
+
+func Test_WebhookSignatureStripe(t *testing.T) {
+	sig := NewWebhookSignature(StripeSignature, "whsec_test")
+	body := `{"type":"invoice.paid"}`
+	verify := func(timestamp time.Time, secret string) error {
+		ts := strconv.FormatInt(timestamp.Unix(), 10)
+		req, err := http.NewRequest("POST", "http://localhost:8384/hooks", nil)
+		if err != nil {
+			t.Error(err)
+		}
+		req.Header.Set("Stripe-Signature", "t="+ts+",v1="+hmacHex(secret, ts+"."+body)+",v0=ignored")
+		return sig.Verify(req, []byte(body))
+	}
+	now := time.Now()
+	expect(t, verify(now, "whsec_test"), nil)
+	expect(t, errors.Is(verify(now, "whsec_test"), ErrSignatureReplayed), true)
+	expect(t, errors.Is(verify(now.Add(-10*time.Minute), "whsec_test"), ErrSignatureExpired), true)
+	expect(t, errors.Is(verify(now.Add(-time.Second), "whsec_other"), ErrSignatureInvalid), true)
+}
+
+func Test_WebhookSignatureCustom(t *testing.T) {
+	sig := NewWebhookSignature(HMACSignature("X-Signature", "X-Timestamp"), "secret")
+	sig.Nonces = nil
+	body := "payload"
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req, err := http.NewRequest("POST", "http://localhost:8384/hooks", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	req.Header.Set("X-Signature", hmacHex("secret", ts+"."+body))
+	req.Header.Set("X-Timestamp", ts)
+	expect(t, sig.Verify(req, []byte(body)), nil)
+	// Replays are allowed without a nonce cache.
+	expect(t, sig.Verify(req, []byte(body)), nil)
+	req.Header.Del("X-Timestamp")
+	expect(t, errors.Is(sig.Verify(req, []byte(body)), ErrSignatureMissing), true)
+}
+
+func Test_NonceCache(t *testing.T) {
+	cache := NewNonceCache(20 * time.Millisecond)
+	expect(t, cache.Seen("a"), false)
+	expect(t, cache.Seen("a"), true)
+	time.Sleep(30 * time.Millisecond)
+	expect(t, cache.Seen("a"), false)
+}
+
+func Test_WebhookSignatureNilBody(t *testing.T) {
+	e := New(NewWebhookSignature(GitHubSignature, "secret"))
+	req := httptest.NewRequest("POST", "/hooks", nil)
+	req.Body = nil
+	req.Header.Set("X-Hub-Signature-256", "sha256="+hmacHex("secret", ""))
+	recorder := httptest.NewRecorder()
+	e.ServeHTTP(recorder, req)
+	expect(t, recorder.Code, http.StatusOK)
+}